@@ -123,7 +123,7 @@ func prepare(api ton.APIClientWrapped, name string, gate *adnl.Gateway, dhtClien
 		return
 	}
 
-	tr := transport2.NewServer(dhtClient, gate, key, channelKey, isServer)
+	tr := transport2.NewServer(dhtClient, gate, key, channelKey, isServer, transport2.DefaultDHTBackoff)
 
 	var seqno uint32
 	if bo, err := fdb.GetBlockOffset(context.Background()); err != nil {
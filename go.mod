@@ -1,6 +1,6 @@
 module github.com/xssnick/ton-payment-network
 
-go 1.19
+go 1.20
 
 require (
 	github.com/rs/zerolog v1.30.0
@@ -19,14 +19,42 @@ import (
 	"time"
 )
 
+type ChannelOpenStep string
+
+const (
+	ChannelOpenStepConfig ChannelOpenStep = "config"
+	// ChannelOpenStepDeploy - covers funding confirmation too, deploy transaction is sent
+	// with capacity attached and waited in the same wallet call, so there is no separate step for it
+	ChannelOpenStepDeploy         ChannelOpenStep = "deploy"
+	ChannelOpenStepInboundRequest ChannelOpenStep = "inbound-request"
+)
+
+// ChannelOpenError - describes at which step channel opening was failed, and why
+type ChannelOpenError struct {
+	Step ChannelOpenStep
+	Err  error
+}
+
+func (e *ChannelOpenError) Error() string {
+	return fmt.Sprintf("channel open failed at %s step: %s", e.Step, e.Err.Error())
+}
+
+func (e *ChannelOpenError) Unwrap() error {
+	return e.Err
+}
+
 func (s *Service) DeployChannelWithNode(ctx context.Context, capacity tlb.Coins, nodeKey ed25519.PublicKey) (*address.Address, error) {
 	cfg, err := s.transport.GetChannelConfig(ctx, nodeKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get channel config: %w", err)
+		return nil, &ChannelOpenError{Step: ChannelOpenStepConfig, Err: fmt.Errorf("failed to get channel config: %w", err)}
 	}
 
 	log.Info().Msg("starting channel deploy")
-	return s.deployChannelWithNode(ctx, nodeKey, address.NewAddress(0, 0, cfg.WalletAddr), capacity)
+	addr, err := s.deployChannelWithNode(ctx, nodeKey, address.NewAddress(0, 0, cfg.WalletAddr), capacity)
+	if err != nil {
+		return nil, &ChannelOpenError{Step: ChannelOpenStepDeploy, Err: err}
+	}
+	return addr, nil
 }
 
 func (s *Service) deployChannelWithNode(ctx context.Context, nodeKey ed25519.PublicKey, nodeAddr *address.Address, capacity tlb.Coins) (*address.Address, error) {
@@ -232,13 +260,9 @@ func (s *Service) CloseVirtualChannel(ctx context.Context, virtualKey ed25519.Pu
 }
 
 func (s *Service) RequestInboundChannel(ctx context.Context, capacity tlb.Coins, theirKey ed25519.PublicKey) error {
-	res, err := s.transport.RequestInboundChannel(ctx, capacity.Nano(), s.wallet.Address(), s.key.Public().(ed25519.PublicKey), theirKey)
+	_, err := s.transport.RequestInboundChannel(ctx, capacity.Nano(), nil, s.wallet.Address(), s.key.Public().(ed25519.PublicKey), theirKey)
 	if err != nil {
-		return fmt.Errorf("failed to request inbound channel: %w", err)
-	}
-
-	if !res.Agreed {
-		return fmt.Errorf("inbound channel request declined with reason: %s", res.Reason)
+		return &ChannelOpenError{Step: ChannelOpenStepInboundRequest, Err: fmt.Errorf("failed to request inbound channel: %w", err)}
 	}
 	return nil
 }
@@ -0,0 +1,150 @@
+package tonpayments
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"github.com/xssnick/ton-payment-network/tonpayments/db"
+	"github.com/xssnick/ton-payment-network/tonpayments/transport"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type testTransport struct {
+	Transport
+	getChannelConfig func(ctx context.Context, theirChannelKey ed25519.PublicKey) (*transport.ChannelConfig, error)
+
+	requestInboundChannel func(ctx context.Context, capacity *big.Int, jettonMaster, ourWallet *address.Address, ourKey, theirKey []byte) (*transport.Decision, error)
+	requestAction         func(ctx context.Context, channelAddr *address.Address, theirChannelKey []byte, action transport.Action) (*transport.Decision, error)
+}
+
+func (t *testTransport) GetChannelConfig(ctx context.Context, theirChannelKey ed25519.PublicKey) (*transport.ChannelConfig, error) {
+	return t.getChannelConfig(ctx, theirChannelKey)
+}
+
+func (t *testTransport) RequestInboundChannel(ctx context.Context, capacity *big.Int, jettonMaster, ourWallet *address.Address, ourKey, theirKey []byte) (*transport.Decision, error) {
+	return t.requestInboundChannel(ctx, capacity, jettonMaster, ourWallet, ourKey, theirKey)
+}
+
+func (t *testTransport) RequestAction(ctx context.Context, channelAddr *address.Address, theirChannelKey []byte, action transport.Action) (*transport.Decision, error) {
+	return t.requestAction(ctx, channelAddr, theirChannelKey, action)
+}
+
+type testDB struct {
+	DB
+	getChannelsWithKey func(ctx context.Context, key ed25519.PublicKey) ([]*db.Channel, error)
+	getChannel         func(ctx context.Context, addr string) (*db.Channel, error)
+	updateChannel      func(ctx context.Context, channel *db.Channel) error
+
+	getVirtualChannelMeta func(ctx context.Context, key []byte) (*db.VirtualChannelMeta, error)
+
+	retryTask    func(ctx context.Context, task *db.Task, reason string, retryAt time.Time) error
+	completeTask func(ctx context.Context, task *db.Task) error
+}
+
+func (t *testDB) GetChannelsWithKey(ctx context.Context, key ed25519.PublicKey) ([]*db.Channel, error) {
+	return t.getChannelsWithKey(ctx, key)
+}
+
+func (t *testDB) GetChannel(ctx context.Context, addr string) (*db.Channel, error) {
+	return t.getChannel(ctx, addr)
+}
+
+func (t *testDB) UpdateChannel(ctx context.Context, channel *db.Channel) error {
+	return t.updateChannel(ctx, channel)
+}
+
+func (t *testDB) GetVirtualChannelMeta(ctx context.Context, key []byte) (*db.VirtualChannelMeta, error) {
+	return t.getVirtualChannelMeta(ctx, key)
+}
+
+func (t *testDB) RetryTask(ctx context.Context, task *db.Task, reason string, retryAt time.Time) error {
+	return t.retryTask(ctx, task, reason, retryAt)
+}
+
+func (t *testDB) CompleteTask(ctx context.Context, task *db.Task) error {
+	return t.completeTask(ctx, task)
+}
+
+func TestService_DeployChannelWithNode_FailedStep(t *testing.T) {
+	errConfig := errors.New("config unavailable")
+	errDB := errors.New("db unavailable")
+
+	for _, tt := range []struct {
+		name      string
+		configErr error
+		step      ChannelOpenStep
+		cause     error
+	}{
+		{"config", errConfig, ChannelOpenStepConfig, errConfig},
+		{"deploy", nil, ChannelOpenStepDeploy, errDB},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &Service{
+				transport: &testTransport{
+					getChannelConfig: func(ctx context.Context, theirChannelKey ed25519.PublicKey) (*transport.ChannelConfig, error) {
+						if tt.configErr != nil {
+							return nil, tt.configErr
+						}
+						return &transport.ChannelConfig{WalletAddr: make([]byte, 32)}, nil
+					},
+				},
+				db: &testDB{
+					getChannelsWithKey: func(ctx context.Context, key ed25519.PublicKey) ([]*db.Channel, error) {
+						return nil, errDB
+					},
+				},
+			}
+
+			_, err := svc.DeployChannelWithNode(context.Background(), tlb.MustFromTON("1"), make(ed25519.PublicKey, 32))
+
+			var openErr *ChannelOpenError
+			if !errors.As(err, &openErr) {
+				t.Fatal("not a channel open error:", err)
+			}
+			if openErr.Step != tt.step {
+				t.Fatal("incorrect step", openErr.Step)
+			}
+			if !errors.Is(err, tt.cause) {
+				t.Fatal("cause is not wrapped:", err)
+			}
+		})
+	}
+}
+
+func TestService_RequestInboundChannel_FailedStep(t *testing.T) {
+	errRejected := errors.New("capacity is too big")
+
+	_, key, _ := ed25519.GenerateKey(nil)
+	w, err := wallet.FromPrivateKey(nil, key, wallet.V3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &Service{
+		key:    key,
+		wallet: w,
+		transport: &testTransport{
+			requestInboundChannel: func(ctx context.Context, capacity *big.Int, jettonMaster, ourWallet *address.Address, ourKey, theirKey []byte) (*transport.Decision, error) {
+				return nil, errRejected
+			},
+		},
+	}
+
+	err = svc.RequestInboundChannel(context.Background(), tlb.MustFromTON("1"), make(ed25519.PublicKey, 32))
+
+	var openErr *ChannelOpenError
+	if !errors.As(err, &openErr) {
+		t.Fatal("not a channel open error:", err)
+	}
+	if openErr.Step != ChannelOpenStepInboundRequest {
+		t.Fatal("incorrect step", openErr.Step)
+	}
+	if !errors.Is(err, errRejected) {
+		t.Fatal("cause is not wrapped:", err)
+	}
+}
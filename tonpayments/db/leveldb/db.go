@@ -62,7 +62,7 @@ func NewDB(path string) (*DB, error) {
 }
 
 func (d *DB) Close() {
-	d.Close()
+	_ = d._db.Close()
 }
 
 const txKey = "__ldbTx"
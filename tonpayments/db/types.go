@@ -47,6 +47,12 @@ type Channel struct {
 
 	AcceptingActions bool
 
+	// CloseTxHash - settlement transaction of cooperative close, reported by party, to track it onchain
+	CloseTxHash []byte
+
+	// Settlement - schedule of onchain settlement agreed with party, nil when not agreed
+	Settlement *SettlementPolicy
+
 	Our   Side
 	Their Side
 
@@ -58,6 +64,12 @@ type Channel struct {
 	mx sync.RWMutex
 }
 
+// SettlementPolicy - zero Interval or Threshold means that it is not used
+type SettlementPolicy struct {
+	Interval  time.Duration
+	Threshold *big.Int
+}
+
 type OnchainState struct {
 	Key            ed25519.PublicKey
 	CommittedSeqno uint32
@@ -39,38 +39,142 @@ func (s *Service) ProcessAction(ctx context.Context, key ed25519.PublicKey, chan
 		return nil, err
 	}
 
+	toExecute, changed, err := s.applyAction(channel, key, signedState, action)
+	if err != nil {
+		return nil, err
+	}
+
+	if changed {
+		if err = s.db.Transaction(context.Background(), func(ctx context.Context) error {
+			if toExecute != nil {
+				if err = toExecute(ctx); err != nil {
+					return err
+				}
+			}
+			if err = s.db.UpdateChannel(ctx, channel); err != nil {
+				return fmt.Errorf("failed to update channel in db: %w", err)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &channel.Our.SignedSemiChannel, nil
+}
+
+// ProcessActions - applies batch of actions all-or-nothing, nothing is persisted if any action is rejected.
+// Actions can touch the same channel, then each next action should be based on the state from the previous one.
+func (s *Service) ProcessActions(ctx context.Context, key ed25519.PublicKey, proposals []transport.ActionProposal) ([]*payments.SignedSemiChannel, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	var changed []*db.Channel
+	var toExecute []func(ctx context.Context) error
+	channels := map[string]*db.Channel{}
+	results := make([]*payments.SignedSemiChannel, 0, len(proposals))
+	for i, p := range proposals {
+		addr := p.ChannelAddr.String()
+
+		channel := channels[addr]
+		if channel == nil {
+			var err error
+			if channel, err = s.getVerifiedChannel(addr); err != nil {
+				return nil, fmt.Errorf("action %d: %w", i, err)
+			}
+			channels[addr] = channel
+		}
+
+		execute, ok, err := s.applyAction(channel, key, p.SignedState, p.Action)
+		if err != nil {
+			return nil, fmt.Errorf("action %d: %w", i, err)
+		}
+
+		if ok {
+			if execute != nil {
+				toExecute = append(toExecute, execute)
+			}
+
+			found := false
+			for _, ch := range changed {
+				if ch == channel {
+					found = true
+					break
+				}
+			}
+			if !found {
+				changed = append(changed, channel)
+			}
+		}
+
+		// copy state, because channel can be changed by the next action
+		cl, err := tlb.ToCell(channel.Our.SignedSemiChannel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize our state: %w", err)
+		}
+		var res payments.SignedSemiChannel
+		if err = tlb.LoadFromCell(&res, cl.BeginParse()); err != nil {
+			return nil, fmt.Errorf("failed to copy our state: %w", err)
+		}
+		results = append(results, &res)
+	}
+
+	if len(changed) > 0 {
+		if err := s.db.Transaction(context.Background(), func(ctx context.Context) error {
+			for _, execute := range toExecute {
+				if err := execute(ctx); err != nil {
+					return err
+				}
+			}
+			for _, channel := range changed {
+				if err := s.db.UpdateChannel(ctx, channel); err != nil {
+					return fmt.Errorf("failed to update channel in db: %w", err)
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// applyAction - validates action and applies it to the channel in memory, changes are not persisted.
+// Returned func must be executed in the same db transaction with channel update.
+// When action was already applied before, changed is false.
+func (s *Service) applyAction(channel *db.Channel, key ed25519.PublicKey, signedState payments.SignedSemiChannel, action transport.Action) (toExecute func(ctx context.Context) error, changed bool, err error) {
 	if channel.Status != db.ChannelStateActive {
-		return nil, fmt.Errorf("channel is not active")
+		return nil, false, fmt.Errorf("channel is not active")
 	}
 
 	if !channel.AcceptingActions {
-		return nil, fmt.Errorf("channel is currently not accepting new actions")
+		return nil, false, fmt.Errorf("channel is currently not accepting new actions")
 	}
 
 	if !bytes.Equal(key, channel.TheirOnchain.Key) {
-		return nil, fmt.Errorf("incorrect channel key")
+		return nil, false, fmt.Errorf("incorrect channel key")
 	}
 
 	if err = signedState.Verify(channel.TheirOnchain.Key); err != nil {
-		return nil, fmt.Errorf("failed to verify passed state: %w", err)
+		return nil, false, fmt.Errorf("failed to verify passed state: %w", err)
 	}
 
 	if signedState.State.Data.Sent.Nano().Cmp(channel.Their.State.Data.Sent.Nano()) == -1 {
-		return nil, fmt.Errorf("amount decrease is not allowed")
+		return nil, false, fmt.Errorf("amount decrease is not allowed")
 	}
 
-	var toExecute func(ctx context.Context) error
 	if signedState.State.Data.Seqno == channel.Their.State.Data.Seqno {
 		// idempotency check
 		channel.Their.Signature = signedState.Signature
 		if err = channel.Their.Verify(channel.TheirOnchain.Key); err != nil {
-			return nil, fmt.Errorf("inconsistent state, this seqno with different content was already committed")
+			return nil, false, fmt.Errorf("inconsistent state, this seqno with different content was already committed")
 		}
-		return &channel.Our.SignedSemiChannel, nil
+		return nil, false, nil
 	}
 
 	if signedState.State.Data.Seqno != channel.Their.State.Data.Seqno+1 {
-		return nil, fmt.Errorf("incorrect state seqno %d, want %d", signedState.State.Data.Seqno, channel.Their.State.Data.Seqno+1)
+		return nil, false, fmt.Errorf("incorrect state seqno %d, want %d", signedState.State.Data.Seqno, channel.Their.State.Data.Seqno+1)
 	}
 
 	log.Debug().Type("action", action).Msg("action process")
@@ -103,32 +207,32 @@ func (s *Service) ProcessAction(ctx context.Context, key ed25519.PublicKey, chan
 	case transport.RemoveVirtualAction:
 		index, _, err := signedState.State.FindVirtualChannel(data.Key)
 		if err != nil && !errors.Is(err, payments.ErrNotFound) {
-			return nil, fmt.Errorf("failed to find virtual channel in their new state: %w", err)
+			return nil, false, fmt.Errorf("failed to find virtual channel in their new state: %w", err)
 		}
 		if err == nil {
-			return nil, fmt.Errorf("condition should be removed to unlock")
+			return nil, false, fmt.Errorf("condition should be removed to unlock")
 		}
 
 		index, vch, err := channel.Their.State.FindVirtualChannel(data.Key)
 		if err != nil {
 			if errors.Is(err, payments.ErrNotFound) {
 				// idempotency
-				return &channel.Our.SignedSemiChannel, nil
+				return nil, false, nil
 			}
-			return nil, fmt.Errorf("failed to find virtual channel in their prev state: %w", err)
+			return nil, false, fmt.Errorf("failed to find virtual channel in their prev state: %w", err)
 		}
 
 		meta, err := s.db.GetVirtualChannelMeta(context.Background(), vch.Key)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load virtual channel meta: %w", err)
+			return nil, false, fmt.Errorf("failed to load virtual channel meta: %w", err)
 		}
 
 		if vch.Deadline >= time.Now().Unix() && !meta.ReadyToReleaseCoins {
-			return nil, fmt.Errorf("virtual channel is not expired")
+			return nil, false, fmt.Errorf("virtual channel is not expired")
 		}
 
 		if err = channel.Their.State.Data.Conditionals.SetIntKey(index, nil); err != nil {
-			return nil, fmt.Errorf("failed to remove condition with index %s: %w", index.String(), err)
+			return nil, false, fmt.Errorf("failed to remove condition with index %s: %w", index.String(), err)
 		}
 
 		toExecute = func(ctx context.Context) error {
@@ -143,63 +247,63 @@ func (s *Service) ProcessAction(ctx context.Context, key ed25519.PublicKey, chan
 	case transport.ConfirmCloseAction:
 		index, _, err := signedState.State.FindVirtualChannel(data.Key)
 		if err != nil && !errors.Is(err, payments.ErrNotFound) {
-			return nil, fmt.Errorf("failed to find virtual channel in their new state: %w", err)
+			return nil, false, fmt.Errorf("failed to find virtual channel in their new state: %w", err)
 		}
 		if err == nil {
-			return nil, fmt.Errorf("condition should be removed to close")
+			return nil, false, fmt.Errorf("condition should be removed to close")
 		}
 
 		index, vch, err := channel.Their.State.FindVirtualChannel(data.Key)
 		if err != nil {
 			if errors.Is(err, payments.ErrNotFound) {
 				// idempotency
-				return &channel.Our.SignedSemiChannel, nil
+				return nil, false, nil
 			}
-			return nil, fmt.Errorf("failed to find virtual channel in their prev state: %w", err)
+			return nil, false, fmt.Errorf("failed to find virtual channel in their prev state: %w", err)
 		}
 
 		balanceDiff := new(big.Int).Sub(signedState.State.Data.Sent.Nano(), channel.Their.State.Data.Sent.Nano())
 
 		var vState payments.VirtualChannelState
 		if err = tlb.LoadFromCell(&vState, data.State.BeginParse()); err != nil {
-			return nil, fmt.Errorf("failed to load virtual channel state cell: %w", err)
+			return nil, false, fmt.Errorf("failed to load virtual channel state cell: %w", err)
 		}
 
 		if !vState.Verify(vch.Key) {
-			return nil, fmt.Errorf("incorrect channel state signature")
+			return nil, false, fmt.Errorf("incorrect channel state signature")
 		}
 
 		if vState.Amount.Nano().Cmp(vch.Capacity) == 1 {
-			return nil, fmt.Errorf("amount cannot be > capacity")
+			return nil, false, fmt.Errorf("amount cannot be > capacity")
 		}
 
 		gotAmt := new(big.Int).Add(vState.Amount.Nano(), vch.Fee)
 		if gotAmt.Cmp(balanceDiff) == -1 {
-			return nil, fmt.Errorf("incorrect amount unlocked: %s instead of %s", balanceDiff.String(), vState.Amount.Nano().String())
+			return nil, false, fmt.Errorf("incorrect amount unlocked: %s instead of %s", balanceDiff.String(), vState.Amount.Nano().String())
 		}
 
 		meta, err := s.db.GetVirtualChannelMeta(context.Background(), vch.Key)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load virtual channel meta: %w", err)
+			return nil, false, fmt.Errorf("failed to load virtual channel meta: %w", err)
 		}
 
 		if !meta.Active {
-			return nil, fmt.Errorf("virtual channel is inactive")
+			return nil, false, fmt.Errorf("virtual channel is inactive")
 		}
 		if !meta.ReadyToReleaseCoins {
-			return nil, fmt.Errorf("virtual channel close was not requested")
+			return nil, false, fmt.Errorf("virtual channel close was not requested")
 		}
 
 		if res := meta.GetKnownResolve(vch.Key); res != nil {
 			if res.Amount.Nano().Cmp(vState.Amount.Nano()) == 1 {
-				return nil, fmt.Errorf("outdated virtual channel state")
+				return nil, false, fmt.Errorf("outdated virtual channel state")
 			}
 		} else {
-			return nil, fmt.Errorf("resolve is unknown on node side")
+			return nil, false, fmt.Errorf("resolve is unknown on node side")
 		}
 
 		if err = channel.Their.State.Data.Conditionals.DeleteIntKey(index); err != nil {
-			return nil, fmt.Errorf("failed to remove condition with index %s: %w", index.String(), err)
+			return nil, false, fmt.Errorf("failed to remove condition with index %s: %w", index.String(), err)
 		}
 
 		toExecute = func(ctx context.Context) error {
@@ -218,65 +322,72 @@ func (s *Service) ProcessAction(ctx context.Context, key ed25519.PublicKey, chan
 	case transport.OpenVirtualAction:
 		index, vch, err := signedState.State.FindVirtualChannel(data.ChannelKey)
 		if err != nil {
-			return nil, fmt.Errorf("failed to find virtual channel in their new state: %w", err)
+			return nil, false, fmt.Errorf("failed to find virtual channel in their new state: %w", err)
 		}
 
 		if vch.Capacity.Sign() <= 0 {
-			return nil, fmt.Errorf("invalid capacity")
+			return nil, false, fmt.Errorf("invalid capacity")
 		}
 
 		if vch.Fee.Sign() < 0 {
-			return nil, fmt.Errorf("invalid fee")
+			return nil, false, fmt.Errorf("invalid fee")
 		}
 
 		if vch.Deadline < time.Now().Unix()+channel.SafeOnchainClosePeriod {
-			return nil, fmt.Errorf("too short virtual channel deadline")
+			return nil, false, fmt.Errorf("too short virtual channel deadline")
 		}
 
 		_, oldVC, err := channel.Their.State.FindVirtualChannel(data.ChannelKey)
 		if err != nil && !errors.Is(err, payments.ErrNotFound) {
-			return nil, fmt.Errorf("failed to find virtual channel in their prev state: %w", err)
+			return nil, false, fmt.Errorf("failed to find virtual channel in their prev state: %w", err)
 		}
 		if err == nil {
 			if oldVC.Deadline == vch.Deadline && oldVC.Fee.Cmp(vch.Fee) == 0 && oldVC.Capacity.Cmp(vch.Capacity) == 0 {
 				// idempotency
-				return &channel.Our.SignedSemiChannel, nil
+				return nil, false, nil
 			}
-			return nil, fmt.Errorf("channel with this key is already exists and has different configuration")
+			return nil, false, fmt.Errorf("channel with this key is already exists and has different configuration")
 		}
 
 		if _, err = s.db.GetVirtualChannelMeta(context.Background(), vch.Key); err != nil && !errors.Is(err, db.ErrNotFound) {
-			return nil, fmt.Errorf("failed to load virtual channel meta: %w", err)
+			return nil, false, fmt.Errorf("failed to load virtual channel meta: %w", err)
 		}
 		if err == nil {
-			return nil, fmt.Errorf("this virtual channel key was already used before")
+			return nil, false, fmt.Errorf("this virtual channel key was already used before")
+		}
+
+		currentInstruction, err := data.DecryptOurInstruction(s.key, data.InstructionKey)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decrypt instruction: %w", err)
+		}
+
+		expFee := new(big.Int).SetBytes(currentInstruction.ExpectedFee)
+		expCap := new(big.Int).SetBytes(currentInstruction.ExpectedCapacity)
+
+		if expFee.Cmp(vch.Fee) != 0 || expCap.Cmp(vch.Capacity) != 0 || currentInstruction.ExpectedDeadline != vch.Deadline {
+			return nil, false, fmt.Errorf("incorrect values, not equals to expected")
+		}
+
+		// we see only previous and next hop, so this is the only loop we can detect,
+		// it is rejected before anything is locked for the tunnel
+		if !bytes.Equal(currentInstruction.NextTarget, s.key.Public().(ed25519.PublicKey)) &&
+			bytes.Equal(currentInstruction.NextTarget, channel.TheirOnchain.Key) {
+			return nil, false, fmt.Errorf("loop detected, next target is the previous hop")
 		}
 
 		// we put our serialized condition to make sure that party is not cheated,
 		// if something diff will be in state, final signature will not match
 		if err = channel.Their.State.Data.Conditionals.SetIntKey(index, vch.Serialize()); err != nil {
-			return nil, fmt.Errorf("failed to settle condition with index %s: %w", index.String(), err)
+			return nil, false, fmt.Errorf("failed to settle condition with index %s: %w", index.String(), err)
 		}
 
 		theirBalance, err := channel.CalcBalance(true)
 		if err != nil {
-			return nil, fmt.Errorf("failed to calc other side balance: %w", err)
+			return nil, false, fmt.Errorf("failed to calc other side balance: %w", err)
 		}
 
 		if theirBalance.Sign() == -1 {
-			return nil, fmt.Errorf("not enough available balance, you need %s more to do this", theirBalance.Abs(theirBalance).String())
-		}
-
-		currentInstruction, err := data.DecryptOurInstruction(s.key, data.InstructionKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt instruction: %w", err)
-		}
-
-		expFee := new(big.Int).SetBytes(currentInstruction.ExpectedFee)
-		expCap := new(big.Int).SetBytes(currentInstruction.ExpectedCapacity)
-
-		if expFee.Cmp(vch.Fee) != 0 || expCap.Cmp(vch.Capacity) != 0 || currentInstruction.ExpectedDeadline != vch.Deadline {
-			return nil, fmt.Errorf("incorrect values, not equals to expected")
+			return nil, false, fmt.Errorf("not enough available balance, you need %s more to do this", theirBalance.Abs(theirBalance).String())
 		}
 
 		if !bytes.Equal(currentInstruction.NextTarget, s.key.Public().(ed25519.PublicKey)) {
@@ -286,26 +397,26 @@ func (s *Service) ProcessAction(ctx context.Context, key ed25519.PublicKey, chan
 			nextCap := new(big.Int).SetBytes(currentInstruction.NextCapacity)
 
 			if currentInstruction.NextDeadline > vch.Deadline-channel.SafeOnchainClosePeriod {
-				return nil, fmt.Errorf("too short next deadline")
+				return nil, false, fmt.Errorf("too short next deadline")
 			}
 
 			if nextCap.Cmp(vch.Capacity) == 1 {
-				return nil, fmt.Errorf("capacity cannot increase")
+				return nil, false, fmt.Errorf("capacity cannot increase")
 			}
 
 			ourFee := new(big.Int).Sub(vch.Fee, nextFee)
 			if ourFee.Cmp(s.virtualChannelFee.Nano()) == -1 {
-				return nil, fmt.Errorf("min fee to open channel is %s TON", s.virtualChannelFee.String())
+				return nil, false, fmt.Errorf("min fee to open channel is %s TON", s.virtualChannelFee.String())
 			}
 
 			targetChannels, err := s.db.GetChannelsWithKey(context.Background(), currentInstruction.NextTarget)
 			if err != nil {
-				return nil, fmt.Errorf("failed to get target channel: %w", err)
+				return nil, false, fmt.Errorf("failed to get target channel: %w", err)
 			}
 			// TODO: tampering checks
 
 			if len(targetChannels) == 0 {
-				return nil, fmt.Errorf("destination channel is not belongs to this node")
+				return nil, false, fmt.Errorf("destination channel is not belongs to this node")
 			}
 
 			var target *db.Channel
@@ -316,7 +427,7 @@ func (s *Service) ProcessAction(ctx context.Context, key ed25519.PublicKey, chan
 
 				balance, err := targetChannel.CalcBalance(false)
 				if err != nil {
-					return nil, fmt.Errorf("failed to calc our channel %s balance: %w", targetChannel.Address, err)
+					return nil, false, fmt.Errorf("failed to calc our channel %s balance: %w", targetChannel.Address, err)
 				}
 
 				amt := new(big.Int).Add(nextCap, nextFee)
@@ -327,7 +438,7 @@ func (s *Service) ProcessAction(ctx context.Context, key ed25519.PublicKey, chan
 			}
 
 			if target == nil {
-				return nil, fmt.Errorf("not enough balance with target to tunnel requested capacity")
+				return nil, false, fmt.Errorf("not enough balance with target to tunnel requested capacity")
 			}
 
 			// we will execute it only after all checks passed and final signature verify
@@ -379,22 +490,22 @@ func (s *Service) ProcessAction(ctx context.Context, key ed25519.PublicKey, chan
 			}
 		}
 	default:
-		return nil, fmt.Errorf("unexpected action type: %s", reflect.TypeOf(data).String())
+		return nil, false, fmt.Errorf("unexpected action type: %s", reflect.TypeOf(data).String())
 	}
 
 	if channel.Our.IsReady() && signedState.State.CounterpartyData == nil {
-		return nil, fmt.Errorf("counterparty state downgrade attempt")
+		return nil, false, fmt.Errorf("counterparty state downgrade attempt")
 	}
 
 	if signedState.State.CounterpartyData != nil {
 		// if seqno is diff we do additional checks and replace counterparty
 		if signedState.State.CounterpartyData.Seqno != channel.Our.State.Data.Seqno {
-			return nil, fmt.Errorf("counterparty state is incorrect")
+			return nil, false, fmt.Errorf("counterparty state is incorrect")
 		}
 
 		cp, err := channel.Our.State.Data.Copy()
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		// we replace it to our value, if something is incorrect, signature will fail
 		// we are doing copy to not depend on pointer to our state (which may change during exec)
@@ -408,39 +519,29 @@ func (s *Service) ProcessAction(ctx context.Context, key ed25519.PublicKey, chan
 	if err = channel.Their.Verify(channel.TheirOnchain.Key); err != nil {
 		log.Warn().Msg(channel.Their.State.Dump())
 		log.Warn().Msg(signedState.State.Dump())
-		return nil, fmt.Errorf("state looks tampered: %w", err)
+		return nil, false, fmt.Errorf("state looks tampered: %w", err)
 	}
 
 	cp, err := channel.Their.State.Data.Copy()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	// update our counterparty
 	channel.Our.State.CounterpartyData = &cp
 	cl, err := tlb.ToCell(channel.Our.State)
 	if err != nil {
-		return nil, fmt.Errorf("failed to serialize our state for signing: %w", err)
+		return nil, false, fmt.Errorf("failed to serialize our state for signing: %w", err)
 	}
 	channel.Our.Signature = payments.Signature{Value: cl.Sign(s.key)}
 
-	if err = s.db.Transaction(context.Background(), func(ctx context.Context) error {
-		if toExecute != nil {
-			if err = toExecute(ctx); err != nil {
-				return err
-			}
-		}
-		if err = s.db.UpdateChannel(ctx, channel); err != nil {
-			return fmt.Errorf("failed to update channel in db: %w", err)
-		}
-		return nil
-	}); err != nil {
-		return nil, err
-	}
-
-	return &channel.Our.SignedSemiChannel, nil
+	return toExecute, true, nil
 }
 
-func (s *Service) ProcessInboundChannelRequest(ctx context.Context, capacity *big.Int, walletAddr *address.Address, key ed25519.PublicKey) error {
+func (s *Service) ProcessInboundChannelRequest(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+	if jettonMaster != nil {
+		return fmt.Errorf("jetton %s is not supported, only native TON channels can be requested", jettonMaster.String())
+	}
+
 	list, err := s.db.GetChannelsWithKey(context.Background(), key)
 	if err != nil {
 		return fmt.Errorf("failed to get active channels: %w", err)
@@ -454,7 +555,8 @@ func (s *Service) ProcessInboundChannelRequest(ctx context.Context, capacity *bi
 	}
 
 	if usedCapacity.Cmp(s.maxOutboundCapacity.Nano()) == 1 {
-		return fmt.Errorf("maximum outbound capacity reached")
+		// capacity is released when some of the channels are closed
+		return &transport.InsufficientLiquidityError{Reason: "maximum outbound capacity reached"}
 	}
 
 	// TODO: also check inside task to not allow ddos
@@ -473,33 +575,40 @@ func (s *Service) ProcessInboundChannelRequest(ctx context.Context, capacity *bi
 	return nil
 }
 
-func (s *Service) ProcessActionRequest(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, action transport.Action) error {
+// ProcessActionRequest - schedules requested action, returns hash of our current channel state
+func (s *Service) ProcessActionRequest(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, action transport.Action) ([]byte, error) {
 	s.mx.Lock()
 	defer s.mx.Unlock()
 
 	channel, err := s.GetActiveChannel(channelAddr.String())
 	if err != nil {
-		return fmt.Errorf("failed to get channel: %w", err)
+		return nil, fmt.Errorf("failed to get channel: %w", err)
 	}
 
 	if !bytes.Equal(channel.TheirOnchain.Key, key) {
-		return fmt.Errorf("unauthorized channel")
+		return nil, fmt.Errorf("unauthorized channel")
 	}
 
 	log.Debug().Type("action", action).Msg("action request process")
 
+	// actions are applied later by tasks, so only the state they are based on is known now
+	stateCell, err := tlb.ToCell(channel.Our.State)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize our state: %w", err)
+	}
+
 	switch data := action.(type) {
 	case transport.RequestRemoveVirtualAction:
 		if !channel.AcceptingActions {
-			return fmt.Errorf("channel is currently not accepting new actions")
+			return nil, fmt.Errorf("channel is currently not accepting new actions")
 		}
 
 		_, vch, err := channel.Our.State.FindVirtualChannel(data.Key)
 		if err != nil {
 			if errors.Is(err, payments.ErrNotFound) {
-				return fmt.Errorf("virtual channel is not found")
+				return nil, fmt.Errorf("virtual channel is not found")
 			}
-			return fmt.Errorf("failed to find virtual channel: %w", err)
+			return nil, fmt.Errorf("failed to find virtual channel: %w", err)
 		}
 
 		tryTill := time.Unix(vch.Deadline, 0)
@@ -509,33 +618,33 @@ func (s *Service) ProcessActionRequest(ctx context.Context, key ed25519.PublicKe
 				Key: data.Key,
 			}, nil, &tryTill,
 		); err != nil {
-			return fmt.Errorf("failed to create remove-virtual task: %w", err)
+			return nil, fmt.Errorf("failed to create remove-virtual task: %w", err)
 		}
 	case transport.CloseVirtualAction:
 		if !channel.AcceptingActions {
-			return fmt.Errorf("channel is currently not accepting new actions")
+			return nil, fmt.Errorf("channel is currently not accepting new actions")
 		}
 
 		var vState payments.VirtualChannelState
 		if err = tlb.LoadFromCell(&vState, data.State.BeginParse()); err != nil {
-			return fmt.Errorf("failed to load virtual channel state cell: %w", err)
+			return nil, fmt.Errorf("failed to load virtual channel state cell: %w", err)
 		}
 
 		_, vch, err := channel.Our.State.FindVirtualChannel(data.Key)
 		if err != nil {
-			return fmt.Errorf("failed to find virtual channel: %w", err)
+			return nil, fmt.Errorf("failed to find virtual channel: %w", err)
 		}
 
 		if !vState.Verify(vch.Key) {
-			return fmt.Errorf("incorrect channel state signature")
+			return nil, fmt.Errorf("incorrect channel state signature")
 		}
 
 		if vState.Amount.Nano().Cmp(vch.Capacity) == 1 {
-			return fmt.Errorf("amount cannot be > capacity")
+			return nil, fmt.Errorf("amount cannot be > capacity")
 		}
 
 		if vch.Deadline < time.Now().Unix() {
-			return fmt.Errorf("virtual channel is expired")
+			return nil, fmt.Errorf("virtual channel is expired")
 		}
 
 		tryTill := time.Unix(vch.Deadline+(channel.SafeOnchainClosePeriod/2), 0)
@@ -546,23 +655,53 @@ func (s *Service) ProcessActionRequest(ctx context.Context, key ed25519.PublicKe
 				State:      data.State.ToBOC(),
 			}, nil, &tryTill,
 		); err != nil {
-			return fmt.Errorf("failed to create confirm-close-virtual task: %w", err)
+			return nil, fmt.Errorf("failed to create confirm-close-virtual task: %w", err)
 		}
 	case transport.CooperativeCloseAction:
 		var req payments.CooperativeClose
 		err = tlb.LoadFromCell(&req, data.SignedCloseRequest.BeginParse())
 		if err != nil {
-			return fmt.Errorf("failed to serialize their close channel request: %w", err)
+			return nil, fmt.Errorf("failed to serialize their close channel request: %w", err)
 		}
 
 		log.Info().Str("address", channel.Address).Msg("received cooperative close request")
 
 		if err = s.executeCooperativeClose(ctx, &req, channel.Address); err != nil {
-			return fmt.Errorf("failed to execute cooperative close action: %w", err)
+			return nil, fmt.Errorf("failed to execute cooperative close action: %w", err)
 		}
 	default:
-		return fmt.Errorf("unexpected action type: %s", reflect.TypeOf(data).String())
+		return nil, fmt.Errorf("unexpected action type: %s", reflect.TypeOf(data).String())
+	}
+	return stateCell.Hash(), nil
+}
+
+// ProcessCloseConfirmation - party notifies us about submitted settlement transaction of cooperative close
+func (s *Service) ProcessCloseConfirmation(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, txHash []byte) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	channel, err := s.db.GetChannel(ctx, channelAddr.String())
+	if err != nil {
+		return fmt.Errorf("failed to get channel: %w", err)
+	}
+
+	if !bytes.Equal(channel.TheirOnchain.Key, key) {
+		return fmt.Errorf("unauthorized channel")
+	}
+
+	if channel.Status == db.ChannelStateActive && channel.AcceptingActions {
+		return fmt.Errorf("channel close is not agreed")
+	}
+
+	if bytes.Equal(channel.CloseTxHash, txHash) {
+		return nil
+	}
+
+	channel.CloseTxHash = append([]byte{}, txHash...)
+	if err = s.db.UpdateChannel(ctx, channel); err != nil {
+		return fmt.Errorf("failed to update channel in db: %w", err)
 	}
 
+	log.Info().Str("address", channel.Address).Hex("tx", txHash).Msg("party confirmed submitted close transaction")
 	return nil
 }
@@ -0,0 +1,156 @@
+package tonpayments
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"github.com/xssnick/ton-payment-network/pkg/payments"
+	"github.com/xssnick/ton-payment-network/tonpayments/db"
+	"github.com/xssnick/ton-payment-network/tonpayments/transport"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_ProcessCloseConfirmation(t *testing.T) {
+	theirKey := bytes.Repeat([]byte{1}, ed25519.PublicKeySize)
+	addr := address.MustParseAddr("EQAYqo4u7VF0fa4DPAebk4g9lBytj2VFny7pzXR0trjtXQaO")
+	txHash := bytes.Repeat([]byte{7}, 32)
+
+	for _, tt := range []struct {
+		name      string
+		status    db.ChannelStatus
+		accepting bool
+		key       ed25519.PublicKey
+		fail      bool
+	}{
+		{name: "closing", status: db.ChannelStateActive, key: theirKey},
+		{name: "not agreed", status: db.ChannelStateActive, accepting: true, key: theirKey, fail: true},
+		{name: "not party", status: db.ChannelStateActive, key: make(ed25519.PublicKey, ed25519.PublicKeySize), fail: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			channel := &db.Channel{
+				Address:          addr.String(),
+				Status:           tt.status,
+				AcceptingActions: tt.accepting,
+				TheirOnchain:     db.OnchainState{Key: theirKey},
+			}
+
+			var updated *db.Channel
+			svc := &Service{
+				db: &testDB{
+					getChannel: func(ctx context.Context, a string) (*db.Channel, error) {
+						return channel, nil
+					},
+					updateChannel: func(ctx context.Context, ch *db.Channel) error {
+						updated = ch
+						return nil
+					},
+				},
+			}
+
+			err := svc.ProcessCloseConfirmation(context.Background(), tt.key, addr, txHash)
+			if tt.fail {
+				if err == nil {
+					t.Fatal("confirmation should be rejected")
+				}
+				if updated != nil {
+					t.Fatal("channel should not be updated")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if updated == nil || !bytes.Equal(updated.CloseTxHash, txHash) {
+				t.Fatal("close tx hash is not persisted")
+			}
+
+			// repeated confirmation of the same tx changes nothing
+			updated = nil
+			if err = svc.ProcessCloseConfirmation(context.Background(), tt.key, addr, txHash); err != nil {
+				t.Fatal(err)
+			}
+			if updated != nil {
+				t.Fatal("channel should not be updated again")
+			}
+		})
+	}
+}
+
+func TestService_ApplyAction_OpenVirtualLoop(t *testing.T) {
+	_, ourKey, _ := ed25519.GenerateKey(nil)
+	theirPub, theirKey, _ := ed25519.GenerateKey(nil)
+	vchPub, vchKey, _ := ed25519.GenerateKey(nil)
+
+	// sender asks us to forward the tunnel back to itself
+	vch, instructionKey, chain, err := transport.GenerateTunnel(vchPub, []transport.TunnelChainPart{
+		{
+			Target:   ourKey.Public().(ed25519.PublicKey),
+			Capacity: tlb.MustFromTON("1").Nano(),
+			Fee:      tlb.MustFromTON("0.02").Nano(),
+			Deadline: time.Now().Add(2 * time.Hour),
+		},
+		{
+			Target:   theirPub,
+			Capacity: tlb.MustFromTON("1").Nano(),
+			Fee:      tlb.MustFromTON("0.01").Nano(),
+			Deadline: time.Now().Add(time.Hour),
+		},
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	action := transport.OpenVirtualAction{
+		ChannelKey:     vchPub,
+		InstructionKey: instructionKey,
+	}
+	if err = action.SetInstructions(chain, vchKey); err != nil {
+		t.Fatal(err)
+	}
+
+	channelID := make([]byte, 16)
+	signedState := db.NewSide(channelID, 1, 0).SignedSemiChannel
+	if err = signedState.State.Data.Conditionals.SetIntKey(big.NewInt(0), vch.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	stateCell, err := tlb.ToCell(signedState.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedState.Signature = payments.Signature{Value: stateCell.Sign(theirKey)}
+
+	channel := &db.Channel{
+		Address:                address.MustParseAddr("EQAYqo4u7VF0fa4DPAebk4g9lBytj2VFny7pzXR0trjtXQaO").String(),
+		Status:                 db.ChannelStateActive,
+		AcceptingActions:       true,
+		TheirOnchain:           db.OnchainState{Key: theirPub},
+		SafeOnchainClosePeriod: 300,
+		Our:                    db.NewSide(channelID, 0, 0),
+		Their:                  db.NewSide(channelID, 0, 0),
+	}
+
+	// target channels are never looked up, so nil getChannelsWithKey would panic if guard is passed
+	svc := &Service{
+		key: ourKey,
+		db: &testDB{
+			getVirtualChannelMeta: func(ctx context.Context, key []byte) (*db.VirtualChannelMeta, error) {
+				return nil, db.ErrNotFound
+			},
+		},
+	}
+
+	_, _, err = svc.applyAction(channel, theirPub, signedState, action)
+	if err == nil || !strings.Contains(err.Error(), "loop detected") {
+		t.Fatalf("loop should be rejected, got: %v", err)
+	}
+
+	if channel.Their.State.Data.Conditionals.Size() != 0 {
+		t.Fatal("condition should not be locked for looping tunnel")
+	}
+}
@@ -28,7 +28,8 @@ type Transport interface {
 	GetChannelConfig(ctx context.Context, theirChannelKey ed25519.PublicKey) (*transport.ChannelConfig, error)
 	RequestAction(ctx context.Context, channelAddr *address.Address, theirChannelKey []byte, action transport.Action) (*transport.Decision, error)
 	ProposeAction(ctx context.Context, channelAddr *address.Address, theirChannelKey []byte, state *cell.Cell, action transport.Action) (*transport.ProposalDecision, error)
-	RequestInboundChannel(ctx context.Context, capacity *big.Int, ourWallet *address.Address, ourKey, theirKey []byte) (*transport.Decision, error)
+	RequestInboundChannel(ctx context.Context, capacity *big.Int, jettonMaster, ourWallet *address.Address, ourKey, theirKey []byte) (*transport.Decision, error)
+	NegotiateSettlement(ctx context.Context, interval time.Duration, threshold *big.Int, theirChannelKey []byte) (*transport.Decision, error)
 }
 
 type DB interface {
@@ -105,9 +106,79 @@ func (s *Service) GetChannelConfig() transport.ChannelConfig {
 		QuarantineDuration:       s.closingConfig.QuarantineDuration,
 		MisbehaviorFine:          s.closingConfig.MisbehaviorFine.Nano().Bytes(),
 		ConditionalCloseDuration: s.closingConfig.ConditionalCloseDuration,
+		// no minimum, any capacity up to the limit is accepted
+		MinCapacity: big.NewInt(0).Bytes(),
+		// limit is for all our channels with the requester together, not for one request
+		MaxCapacity: s.maxOutboundCapacity.Nano().Bytes(),
 	}
 }
 
+func (s *Service) GetFeeSchedule() transport.FeeSchedule {
+	return transport.FeeSchedule{
+		ExcessFee:         s.excessFee.Nano().Bytes(),
+		VirtualChannelFee: s.virtualChannelFee.Nano().Bytes(),
+		// fees are currently static, but we give limited guarantee to be able to change them later
+		ValidUntil: time.Now().Add(10 * time.Minute).Unix(),
+	}
+}
+
+// GetSharedChannels - returns channels with the party, used by the party to reconcile its state with us
+func (s *Service) GetSharedChannels(ctx context.Context, key ed25519.PublicKey) ([]transport.SharedChannel, error) {
+	channels, err := s.db.GetChannelsWithKey(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channels: %w", err)
+	}
+
+	list := make([]transport.SharedChannel, 0, len(channels))
+	for _, ch := range channels {
+		addr, err := address.ParseAddr(ch.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse channel address %s: %w", ch.Address, err)
+		}
+
+		list = append(list, transport.SharedChannel{
+			Address:    addr.Data(),
+			Status:     int32(ch.Status),
+			OurSeqno:   int64(ch.Our.State.Data.Seqno),
+			TheirSeqno: int64(ch.Their.State.Data.Seqno),
+		})
+	}
+	return list, nil
+}
+
+// GetChannelState - returns our latest signed state of the channel, only if the party is its participant
+func (s *Service) GetChannelState(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*payments.SignedSemiChannel, error) {
+	channel, err := s.getVerifiedChannel(channelAddr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(key, channel.TheirOnchain.Key) {
+		return nil, fmt.Errorf("channel is not shared with the party")
+	}
+
+	state := channel.Our.SignedSemiChannel
+	return &state, nil
+}
+
+// GetBalanceAttestation - our current balance in the channel, only the party of the channel can request it
+func (s *Service) GetBalanceAttestation(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*big.Int, error) {
+	channel, err := s.getVerifiedChannel(channelAddr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(key, channel.TheirOnchain.Key) {
+		return nil, fmt.Errorf("channel is not shared with the party")
+	}
+
+	balance, err := channel.CalcBalance(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calc balance: %w", err)
+	}
+	return balance, nil
+}
+
 func (s *Service) GetChannelsWithNode(ctx context.Context, key ed25519.PublicKey) ([]*db.Channel, error) {
 	return s.db.GetChannelsWithKey(ctx, key)
 }
@@ -400,15 +471,15 @@ func (s *Service) requestAction(ctx context.Context, channelAddress string, acti
 		return fmt.Errorf("failed to get channel: %w", err)
 	}
 
-	decision, err := s.transport.RequestAction(ctx, address.MustParseAddr(channel.Address), channel.TheirOnchain.Key, action)
+	_, err = s.transport.RequestAction(ctx, address.MustParseAddr(channel.Address), channel.TheirOnchain.Key, action)
 	if err != nil {
+		var decErr *transport.DecisionError
+		if errors.As(err, &decErr) {
+			log.Warn().Str("reason", decErr.Reason).Msg("actions request denied")
+			return ErrDenied
+		}
 		return fmt.Errorf("failed to request actions: %w", err)
 	}
-
-	if !decision.Agreed {
-		log.Warn().Str("reason", decision.Reason).Msg("actions request denied")
-		return ErrDenied
-	}
 	return nil
 }
 
@@ -434,14 +505,14 @@ func (s *Service) proposeAction(ctx context.Context, channelAddress string, acti
 
 	res, err := s.transport.ProposeAction(ctx, address.MustParseAddr(channel.Address), channel.TheirOnchain.Key, stateCell, action)
 	if err != nil {
+		var decErr *transport.DecisionError
+		if errors.As(err, &decErr) {
+			log.Warn().Str("reason", decErr.Reason).Msg("actions request denied")
+			return ErrDenied
+		}
 		return fmt.Errorf("failed to propose actions: %w", err)
 	}
 
-	if !res.Agreed {
-		log.Warn().Str("reason", res.Reason).Msg("actions request denied")
-		return ErrDenied
-	}
-
 	var theirState payments.SignedSemiChannel
 	if err := tlb.LoadFromCell(&theirState, res.SignedState.BeginParse()); err != nil {
 		return fmt.Errorf("failed to parse their updated channel state: %w", err)
@@ -0,0 +1,130 @@
+package tonpayments
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/xssnick/ton-payment-network/tonpayments/db"
+	"github.com/xssnick/tonutils-go/tlb"
+	"math/big"
+	"time"
+)
+
+const _MinSettlementInterval = time.Hour
+
+// SettlementPolicy - agreed with party schedule of onchain settlement of channels,
+// zero Interval or Threshold means that it is not used
+type SettlementPolicy struct {
+	Interval  time.Duration
+	Threshold tlb.Coins
+}
+
+// NegotiateSettlement - called when party proposes settlement policy, records it when acceptable
+func (s *Service) NegotiateSettlement(ctx context.Context, key ed25519.PublicKey, interval time.Duration, threshold *big.Int) error {
+	policy := SettlementPolicy{
+		Interval:  interval,
+		Threshold: tlb.FromNanoTON(threshold),
+	}
+	if err := checkSettlementPolicy(policy); err != nil {
+		return err
+	}
+
+	return s.setSettlementPolicy(ctx, key, policy)
+}
+
+// ProposeSettlement - agrees settlement policy with party, and records it on our side
+func (s *Service) ProposeSettlement(ctx context.Context, theirKey ed25519.PublicKey, policy SettlementPolicy) error {
+	if err := checkSettlementPolicy(policy); err != nil {
+		return err
+	}
+
+	channels, err := s.getActiveChannelsWith(ctx, theirKey)
+	if err != nil {
+		return err
+	}
+	if len(channels) == 0 {
+		return fmt.Errorf("no active channels with party")
+	}
+
+	if _, err = s.transport.NegotiateSettlement(ctx, policy.Interval, policy.Threshold.Nano(), theirKey); err != nil {
+		return fmt.Errorf("failed to negotiate settlement: %w", err)
+	}
+
+	return s.setSettlementPolicy(ctx, theirKey, policy)
+}
+
+// GetSettlementPolicy - returns policy agreed with party, false if not agreed yet
+func (s *Service) GetSettlementPolicy(ctx context.Context, key ed25519.PublicKey) (SettlementPolicy, bool, error) {
+	channels, err := s.getActiveChannelsWith(ctx, key)
+	if err != nil {
+		return SettlementPolicy{}, false, err
+	}
+
+	for _, ch := range channels {
+		if ch.Settlement != nil {
+			threshold := ch.Settlement.Threshold
+			if threshold == nil {
+				threshold = big.NewInt(0)
+			}
+
+			return SettlementPolicy{
+				Interval:  ch.Settlement.Interval,
+				Threshold: tlb.FromNanoTON(threshold),
+			}, true, nil
+		}
+	}
+	return SettlementPolicy{}, false, nil
+}
+
+// setSettlementPolicy - stores policy with all active channels with party, so it is kept across restarts.
+// Channels opened after the agreement have no policy until it is agreed again.
+func (s *Service) setSettlementPolicy(ctx context.Context, key ed25519.PublicKey, policy SettlementPolicy) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.db.Transaction(ctx, func(ctx context.Context) error {
+		channels, err := s.getActiveChannelsWith(ctx, key)
+		if err != nil {
+			return err
+		}
+		if len(channels) == 0 {
+			return fmt.Errorf("no active channels with party")
+		}
+
+		for _, ch := range channels {
+			ch.Settlement = &db.SettlementPolicy{
+				Interval:  policy.Interval,
+				Threshold: policy.Threshold.Nano(),
+			}
+			if err = s.db.UpdateChannel(ctx, ch); err != nil {
+				return fmt.Errorf("failed to update channel in db: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Service) getActiveChannelsWith(ctx context.Context, key ed25519.PublicKey) ([]*db.Channel, error) {
+	list, err := s.db.GetChannelsWithKey(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channels: %w", err)
+	}
+
+	var channels []*db.Channel
+	for _, ch := range list {
+		if ch.Status == db.ChannelStateActive {
+			channels = append(channels, ch)
+		}
+	}
+	return channels, nil
+}
+
+func checkSettlementPolicy(policy SettlementPolicy) error {
+	if policy.Interval == 0 && policy.Threshold.Nano().Sign() == 0 {
+		return fmt.Errorf("settlement policy should have interval or threshold")
+	}
+	if policy.Interval != 0 && policy.Interval < _MinSettlementInterval {
+		return fmt.Errorf("settlement interval should be at least %s", _MinSettlementInterval)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package tonpayments
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/xssnick/ton-payment-network/tonpayments/db"
+	"github.com/xssnick/ton-payment-network/tonpayments/db/leveldb"
+	"github.com/xssnick/tonutils-go/tlb"
+	"testing"
+	"time"
+)
+
+func TestService_SettlementPolicy_Persisted(t *testing.T) {
+	theirKey, _, _ := ed25519.GenerateKey(nil)
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	path := t.TempDir()
+	ctx := context.Background()
+
+	ldb, err := leveldb.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ch := range []*db.Channel{
+		{Address: "active", Status: db.ChannelStateActive, TheirOnchain: db.OnchainState{Key: theirKey}},
+		{Address: "closing", Status: db.ChannelStateClosing, TheirOnchain: db.OnchainState{Key: theirKey}},
+		{Address: "other", Status: db.ChannelStateActive, TheirOnchain: db.OnchainState{Key: otherKey}},
+	} {
+		ch.ID = make([]byte, 16)
+		ch.ID[0] = byte(i)
+		ch.Our, ch.Their = db.NewSide(ch.ID, 0, 0), db.NewSide(ch.ID, 0, 0)
+		if err = ldb.CreateChannel(ctx, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := &Service{db: ldb}
+	threshold := tlb.MustFromTON("5")
+	if err = svc.NegotiateSettlement(ctx, theirKey, 2*time.Hour, threshold.Nano()); err != nil {
+		t.Fatal(err)
+	}
+
+	// party without channels has nothing to apply policy to
+	noChannelsKey, _, _ := ed25519.GenerateKey(nil)
+	if err = svc.NegotiateSettlement(ctx, noChannelsKey, 2*time.Hour, threshold.Nano()); err == nil {
+		t.Fatal("policy without channels should be rejected")
+	}
+	ldb.Close()
+
+	// policy is kept across restart
+	ldb, err = leveldb.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	svc = &Service{db: ldb}
+
+	policy, ok, err := svc.GetSettlementPolicy(ctx, theirKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || policy.Interval != 2*time.Hour || policy.Threshold.Nano().Cmp(threshold.Nano()) != 0 {
+		t.Fatal("policy is not persisted", ok, policy)
+	}
+
+	if _, ok, _ = svc.GetSettlementPolicy(ctx, otherKey); ok {
+		t.Fatal("policy is applied to another party")
+	}
+
+	closing, err := ldb.GetChannel(ctx, "closing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closing.Settlement != nil {
+		t.Fatal("policy is applied to not active channel")
+	}
+}
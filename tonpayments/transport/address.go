@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"fmt"
+	"github.com/xssnick/tonutils-go/address"
+)
+
+// AddressCodec - builds addresses from their wire representation in queries,
+// can be replaced when representation changes in the new protocol version.
+type AddressCodec interface {
+	// ChannelAddress - decodes address of the channel contract in specified workchain
+	ChannelAddress(workchain int32, data []byte) (*address.Address, error)
+	// WalletAddress - decodes address of the wallet in specified workchain
+	WalletAddress(workchain int32, data []byte) (*address.Address, error)
+	// JettonMasterAddress - decodes address of the jetton master contract, nil address means native TON
+	JettonMasterAddress(data []byte) (*address.Address, error)
+}
+
+// AddressCodecV1 - 32 bytes account id, channels and wallets can be in basechain or masterchain.
+type AddressCodecV1 struct{}
+
+func (AddressCodecV1) ChannelAddress(workchain int32, data []byte) (*address.Address, error) {
+	if err := checkWorkchain(workchain); err != nil {
+		return nil, fmt.Errorf("unsupported channel workchain: %w", err)
+	}
+	if len(data) != 32 {
+		return nil, fmt.Errorf("incorrect channel address length %d", len(data))
+	}
+	return address.NewAddress(0, byte(workchain), data), nil
+}
+
+func (AddressCodecV1) WalletAddress(workchain int32, data []byte) (*address.Address, error) {
+	if err := checkWorkchain(workchain); err != nil {
+		return nil, fmt.Errorf("unsupported wallet workchain: %w", err)
+	}
+	if len(data) != 32 {
+		return nil, fmt.Errorf("incorrect wallet address length %d", len(data))
+	}
+	return address.NewAddress(0, byte(workchain), data), nil
+}
+
+func (AddressCodecV1) JettonMasterAddress(data []byte) (*address.Address, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) != 32 {
+		return nil, fmt.Errorf("incorrect jetton master address length %d", len(data))
+	}
+	return address.NewAddress(0, 0, data), nil
+}
+
+func checkWorkchain(workchain int32) error {
+	if workchain != 0 && workchain != -1 {
+		return fmt.Errorf("workchain %d is not basechain or masterchain", workchain)
+	}
+	return nil
+}
+
+// SetAddressCodec - overrides codec used to decode addresses from peer queries
+func (s *Server) SetAddressCodec(codec AddressCodec) {
+	s.mx.Lock()
+	s.addrCodec = codec
+	s.mx.Unlock()
+}
+
+func (s *Server) getAddressCodec() AddressCodec {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.addrCodec
+}
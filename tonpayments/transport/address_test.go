@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAddressCodecV1(t *testing.T) {
+	codec := AddressCodecV1{}
+	data := bytes.Repeat([]byte{0xAB}, 32)
+
+	for _, wc := range []int32{0, -1} {
+		addr, err := codec.ChannelAddress(wc, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.Workchain() != wc || !bytes.Equal(addr.Data(), data) {
+			t.Fatal("incorrect channel address", addr.String())
+		}
+
+		addr, err = codec.WalletAddress(wc, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.Workchain() != wc || !bytes.Equal(addr.Data(), data) {
+			t.Fatal("incorrect wallet address", addr.String())
+		}
+	}
+
+	for _, bad := range [][]byte{nil, make([]byte, 31), make([]byte, 33)} {
+		if _, err := codec.ChannelAddress(0, bad); err == nil {
+			t.Fatal("malformed channel address accepted", len(bad))
+		}
+		if _, err := codec.WalletAddress(0, bad); err == nil {
+			t.Fatal("malformed wallet address accepted", len(bad))
+		}
+	}
+
+	if _, err := codec.ChannelAddress(7, data); err == nil {
+		t.Fatal("unsupported channel workchain accepted")
+	}
+	if _, err := codec.WalletAddress(7, data); err == nil {
+		t.Fatal("unsupported wallet workchain accepted")
+	}
+
+	addr, err := codec.JettonMasterAddress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.Workchain() != 0 || !bytes.Equal(addr.Data(), data) {
+		t.Fatal("incorrect jetton master address", addr.String())
+	}
+	if addr, err = codec.JettonMasterAddress(nil); err != nil || addr != nil {
+		t.Fatal("empty jetton master should be native TON", err)
+	}
+	if _, err = codec.JettonMasterAddress(make([]byte, 31)); err == nil {
+		t.Fatal("malformed jetton master address accepted")
+	}
+}
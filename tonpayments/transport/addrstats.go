@@ -0,0 +1,60 @@
+package transport
+
+import "sync"
+
+// max number of tracked addresses, to not grow unbounded when peers advertise garbage
+const _MaxAddressStats = 4096
+
+// AddressCounters - number of succeeded and failed connection attempts to one peer address
+type AddressCounters struct {
+	Success uint64
+	Failure uint64
+}
+
+// SuccessRate - share of succeeded attempts from 0 to 1, zero when there were no attempts
+func (c AddressCounters) SuccessRate() float64 {
+	total := c.Success + c.Failure
+	if total == 0 {
+		return 0
+	}
+	return float64(c.Success) / float64(total)
+}
+
+type addressStats struct {
+	list map[string]AddressCounters
+	mx   sync.Mutex
+}
+
+func (a *addressStats) add(addr string, err error) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	if a.list == nil {
+		a.list = map[string]AddressCounters{}
+	}
+
+	c, ok := a.list[addr]
+	if !ok && len(a.list) >= _MaxAddressStats {
+		return
+	}
+
+	if err == nil {
+		c.Success++
+	} else {
+		c.Failure++
+	}
+	a.list[addr] = c
+}
+
+// AddressStats - returns copy of connection counters by peer address (ip:port), collected since server start.
+// Addresses with low success rate may indicate stale or polluted dht records.
+func (s *Server) AddressStats() map[string]AddressCounters {
+	s.addrStats.mx.Lock()
+	defer s.addrStats.mx.Unlock()
+
+	res := make(map[string]AddressCounters, len(s.addrStats.list))
+	for k, v := range s.addrStats.list {
+		res[k] = v
+	}
+	return res
+}
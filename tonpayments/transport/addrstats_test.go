@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestServer_AddressStats(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	a.SetTimeouts(Timeouts{ConnectAddressTimeout: 300 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// first address is not responding, so we fail over to the second one
+	bad := "127.0.0.1:1"
+	if _, err := a.connectAddresses(ctx, b.key.Public().(ed25519.PublicKey), []string{bad, testAddr(b)}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := a.AddressStats()
+	if c := stats[bad]; c.Failure != 1 || c.Success != 0 || c.SuccessRate() != 0 {
+		t.Fatal("incorrect bad address counters", c)
+	}
+	if c := stats[testAddr(b)]; c.Success != 1 || c.Failure != 0 || c.SuccessRate() != 1 {
+		t.Fatal("incorrect good address counters", c)
+	}
+}
+
+func TestAddressStats_Rate(t *testing.T) {
+	var st addressStats
+	for i := 0; i < 3; i++ {
+		st.add("1.1.1.1:1", nil)
+	}
+	st.add("1.1.1.1:1", fmt.Errorf("timeout"))
+
+	if rate := st.list["1.1.1.1:1"].SuccessRate(); rate != 0.75 {
+		t.Fatal("incorrect rate", rate)
+	}
+	if rate := (AddressCounters{}).SuccessRate(); rate != 0 {
+		t.Fatal("incorrect rate without attempts", rate)
+	}
+
+	for i := 0; i < _MaxAddressStats+10; i++ {
+		st.add(fmt.Sprintf("10.0.0.1:%d", i), nil)
+	}
+	if len(st.list) != _MaxAddressStats {
+		t.Fatal("stats are not limited", len(st.list))
+	}
+}
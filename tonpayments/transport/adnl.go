@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
 	"github.com/xssnick/ton-payment-network/pkg/payments"
@@ -16,7 +17,13 @@ import (
 	"github.com/xssnick/tonutils-go/tlb"
 	"github.com/xssnick/tonutils-go/tvm/cell"
 	"math/big"
+	"net"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,93 +35,510 @@ type PeerConnection struct {
 	adnl    adnl.Peer
 	authKey ed25519.PublicKey
 
+	// number of inbound and outbound queries in progress
+	activeTransfers int32
+	// last keepalive round trip time, in nanoseconds
+	rtt int64
+
+	// closed on disconnect
+	stop chan struct{}
+
+	connectedAt time.Time
+	// unix nano time of the last query in any direction, excluding keepalive
+	lastActivity int64
+	// unix nano time of the last successful query in any direction, excluding keepalive
+	lastSuccess int64
+
+	// inbound queries limiters, before and after authentication
+	anonLimiter *tokenBucket
+	authLimiter *tokenBucket
+	// source ip and its limiter, shared by all connections from it
+	ip        string
+	ipLimiter *tokenBucket
+
+	// authenticated using kept session, without handshake
+	resumed bool
+	// adnl key of the node, known only when connection was initiated by us
+	nodeKey ed25519.PublicKey
+
 	mx sync.Mutex
 }
 
+// PeerInfo - snapshot of connected peer state
+type PeerInfo struct {
+	// AuthKey - channel key of the peer, nil when peer is not authenticated yet
+	AuthKey     ed25519.PublicKey
+	ADNLID      []byte
+	RemoteAddr  string
+	ConnectedAt time.Time
+	// LastSuccess - time of the last successful query in any direction, zero when there were none
+	LastSuccess time.Time
+	// Tag - label of the peer set by TagPeer, empty when not set
+	Tag string
+	// ActiveTransfers - number of inbound and outbound queries in progress with peer
+	ActiveTransfers int
+	// RTT - round trip time of the last successful keepalive ping, zero when not measured
+	RTT time.Duration
+}
+
+// ListPeers - returns info about all connected peers, including not authenticated
+func (s *Server) ListPeers() []PeerInfo {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	list := make([]PeerInfo, 0, len(s.peers))
+	for _, p := range s.peers {
+		var key ed25519.PublicKey
+		if p.authKey != nil {
+			key = append(ed25519.PublicKey{}, p.authKey...)
+		}
+
+		list = append(list, PeerInfo{
+			AuthKey:     key,
+			ADNLID:      append([]byte{}, p.adnl.GetID()...),
+			RemoteAddr:  p.adnl.RemoteAddr(),
+			ConnectedAt: p.connectedAt,
+			LastSuccess: p.LastSuccess(),
+			Tag:         s.tags.get(key),
+
+			ActiveTransfers: p.ActiveTransfers(),
+			RTT:             p.RTT(),
+		})
+	}
+	return list
+}
+
+// IsAuthenticated - checks if there is authenticated connection with party, without connecting to it
+func (s *Server) IsAuthenticated(key ed25519.PublicKey) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	p := s.peersByKey[string(key)]
+	return p != nil && p.authKey != nil
+}
+
+// ActiveTransfers - returns number of inbound and outbound queries which are currently in progress with peer
+func (p *PeerConnection) ActiveTransfers() int {
+	return int(atomic.LoadInt32(&p.activeTransfers))
+}
+
+// ActionProposal - parsed action from batch proposal
+type ActionProposal struct {
+	ChannelAddr *address.Address
+	SignedState payments.SignedSemiChannel
+	Action      Action
+}
+
 type Service interface {
 	GetChannelConfig() ChannelConfig
+	GetFeeSchedule() FeeSchedule
+	GetSharedChannels(ctx context.Context, key ed25519.PublicKey) ([]SharedChannel, error)
+	GetChannelState(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*payments.SignedSemiChannel, error)
+	NegotiateSettlement(ctx context.Context, key ed25519.PublicKey, interval time.Duration, threshold *big.Int) error
+	ProcessCloseConfirmation(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, txHash []byte) error
+	// GetBalanceAttestation - returns our current balance in the channel, to be signed and sent to the party
+	GetBalanceAttestation(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*big.Int, error)
 	ProcessAction(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, signedState payments.SignedSemiChannel, action Action) (*payments.SignedSemiChannel, error)
-	ProcessActionRequest(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, action Action) error
-	ProcessInboundChannelRequest(ctx context.Context, capacity *big.Int, walletAddr *address.Address, key ed25519.PublicKey) error
+	ProcessActions(ctx context.Context, key ed25519.PublicKey, proposals []ActionProposal) ([]*payments.SignedSemiChannel, error)
+	// ProcessActionRequest - accepts action to be applied later, returns hash of our channel state
+	// at the moment of acceptance, before the action is applied, can be nil
+	ProcessActionRequest(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, action Action) ([]byte, error)
+	// ProcessInboundChannelRequest - returns InsufficientLiquidityError when request can be repeated later
+	ProcessInboundChannelRequest(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error
+}
+
+// Authorizer - decides if peer authenticated with channel key is allowed to communicate with us,
+// for example, it can check on-chain state of channels with this key.
+type Authorizer interface {
+	Authorize(ctx context.Context, channelKey ed25519.PublicKey) error
+}
+
+// Timeouts - network timings of the server, zero values are replaced with defaults
+type Timeouts struct {
+	// QueryTimeout - max time to wait for the answer, used when caller's context has no deadline,
+	// longer deadline of the caller is respected and not shortened to it
+	QueryTimeout time.Duration
+	// HandleTimeout - max time to process inbound query and send answer
+	HandleTimeout time.Duration
+	// DropPeerAfter - failed query which took longer than this causes reconnect to peer
+	DropPeerAfter time.Duration
+	// ConnectTimeout - max time of connect to peer, including dht resolve and handshake,
+	// it is shared by all callers waiting for the connect, so it is not bound to their deadlines
+	ConnectTimeout time.Duration
+	// ConnectAddressTimeout - max time to establish connection using one of node's addresses,
+	// before trying the next one. Not applied to the last address.
+	ConnectAddressTimeout time.Duration
+	// AuthMaxAge - how old can be timestamp of peer's auth request
+	AuthMaxAge time.Duration
+	// AuthMaxSkew - how far in the future can be timestamp of peer's auth request, to tolerate clock skew
+	AuthMaxSkew time.Duration
+	// MinColdQueryTime - minimal time to connect, authenticate and query not connected peer,
+	// queries to such peers with shorter deadline fail fast with ErrDeadlineTooShort
+	MinColdQueryTime time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	QueryTimeout:  7 * time.Second,
+	HandleTimeout: 10 * time.Second,
+	DropPeerAfter: 3 * time.Second,
+
+	ConnectTimeout:        15 * time.Second,
+	ConnectAddressTimeout: 3 * time.Second,
+
+	AuthMaxAge:  60 * time.Second,
+	AuthMaxSkew: 5 * time.Second,
+
+	MinColdQueryTime: 1 * time.Second,
 }
 
+// ErrPeerUnreachable - connection with peer cannot be established, for example its address is not found in dht
+var ErrPeerUnreachable = errors.New("peer is unreachable")
+
+// ErrAuthFailed - connection is established, but peer is not authenticated
+var ErrAuthFailed = errors.New("peer authentication failed")
+
+// ErrQueryTimeout - peer is not responded in time
+var ErrQueryTimeout = errors.New("query timeout")
+
+// DecisionError - peer received the query, but rejected it with Reason
+type DecisionError struct {
+	Reason string
+}
+
+func (e *DecisionError) Error() string {
+	return "rejected by peer: " + e.Reason
+}
+
+// ErrAnswerTooLarge - answer is not fit into max answer size requested by the peer
+var ErrAnswerTooLarge = errors.New("answer is too large")
+
+// ErrUnexpectedResponse - peer answered with type different from expected, usually means protocol mismatch
+var ErrUnexpectedResponse = errors.New("unexpected response type")
+
+// ErrInternal - unexpected failure on our side, like serialization bug, not caused by peer or network
+var ErrInternal = errors.New("internal error")
+
 type Server struct {
 	svc        Service
+	authorizer Authorizer
+	ephemeral  *ephemeralSigner
 	channelKey ed25519.PrivateKey
 	key        ed25519.PrivateKey
-	dht        *dht.Client
+	dht        DHTClient
 	gate       *adnl.Gateway
 	closeCtx   context.Context
+	// our address is published in dht
+	serverMode bool
 
 	peersByKey map[string]*PeerConnection
 	peers      map[string]*PeerConnection
-	mx         sync.RWMutex
+	// connects in progress by channel key, shared by concurrent callers
+	connecting map[string]*connectCall
+	// zero means no limit
+	maxPeers int
+	// last accepted their state seqno per channel address
+	seqnos map[string]*channelSeqno
+	// pinned node addresses by channel key, used instead of dht
+	staticPeers  map[string]resolvedNode
+	peerTimeouts map[string]time.Duration
+	queryPool    *workerPool
+	connectSem   chan struct{}
+	timeouts     Timeouts
+	mx           sync.RWMutex
+
+	addrCodec AddressCodec
+	metrics   Metrics
+	stats     queryStats
+	addrStats addressStats
+	tags      peerTags
+	// advertised auth algorithms of parties, to detect downgrade
+	capabilities peerCapabilities
+	dhtCache     *dhtCache
+	dhtStale     dhtStaleness
+	dhtTrigger   chan struct{}
+	respCache    responseCache
+	// max answer sizes by query kind, default is used when not set
+	maxAnswerSizes map[string]int64
+	maxMessageSize int64
+	// max of message and answer sizes, read atomically for every packet
+	messageLimit int64
+
+	queryRetries int
+
+	anonLimit RateLimit
+	authLimit RateLimit
+	// limits by source ip, usage is tracked for all connections
+	ipLimit    RateLimit
+	ipMaxConns int
+	ipUsage    map[string]*ipUsage
+
+	idleTimeout time.Duration
+	idleSweeper bool
+
+	onKeyRebind         func(channelKey ed25519.PublicKey, oldADNL, newADNL []byte)
+	onPeerAuthenticated func(key ed25519.PublicKey, inbound bool)
+	onDowngrade         func(channelKey ed25519.PublicKey, prev, now []int32)
+	// connection refused or evicted because of limits
+	onConnectionRejected func(reason error, addr string)
+
+	// payloads logging for debug, by channel key or for all peers
+	traceAll  bool
+	traceKeys map[string]bool
+
+	// last auth failures by channel key
+	authFailures    map[string]*authFailure
+	authBackoffBase time.Duration
+	authBackoffMax  time.Duration
+
+	// auth sessions by channel key, kept for grace period after disconnect
+	sessions map[string]*authSession
+	// the same sessions by adnl id of the party, to find session of reconnected peer
+	sessionsByADNL map[string]*authSession
+	sessionGrace   time.Duration
+	sessionStore   SessionStore
+
+	keepaliveInterval  time.Duration
+	keepaliveMaxMissed int
 
 	closer func()
+	closed bool
+
+	// inbound query handlers in progress
+	handlers sync.WaitGroup
 }
 
-func NewServer(dht *dht.Client, gate *adnl.Gateway, key, channelKey ed25519.PrivateKey, serverMode bool) *Server {
+// NewServer - creates server, when serverMode is true, our address is published in dht
+// and updated according to dhtBackoff schedule, its zero durations are replaced with defaults.
+func NewServer(dht DHTClient, gate *adnl.Gateway, key, channelKey ed25519.PrivateKey, serverMode bool, dhtBackoff DHTBackoff) *Server {
 	s := &Server{
-		channelKey: channelKey,
-		key:        key,
-		dht:        dht,
-		gate:       gate,
-		peersByKey: map[string]*PeerConnection{},
-		peers:      map[string]*PeerConnection{},
+		channelKey:     channelKey,
+		key:            key,
+		serverMode:     serverMode,
+		dht:            dht,
+		gate:           gate,
+		peersByKey:     map[string]*PeerConnection{},
+		peers:          map[string]*PeerConnection{},
+		connecting:     map[string]*connectCall{},
+		seqnos:         map[string]*channelSeqno{},
+		staticPeers:    map[string]resolvedNode{},
+		ipUsage:        map[string]*ipUsage{},
+		peerTimeouts:   map[string]time.Duration{},
+		sessions:       map[string]*authSession{},
+		sessionsByADNL: map[string]*authSession{},
+		authFailures:   map[string]*authFailure{},
+		maxAnswerSizes: map[string]int64{},
+		maxMessageSize: _DefaultMaxMessageSize,
+		messageLimit:   _DefaultMaxMessageSize,
+		queryPool:      newWorkerPool(_DefaultQueryWorkers, _DefaultQueryQueueSize),
+		timeouts:       DefaultTimeouts,
+		addrCodec:      AddressCodecV1{},
+		metrics:        noopMetrics{},
+		dhtCache:       newDHTCache(_DefaultDHTCacheTTL),
+		queryRetries:   _DefaultQueryRetries,
+		dhtTrigger:     make(chan struct{}, 1),
 	}
 	s.closeCtx, s.closer = context.WithCancel(context.Background())
 	s.gate.SetConnectionHandler(s.bootstrapPeerWrap)
 
 	if serverMode {
-		go func() {
-			wait := 1 * time.Second
-			// refresh dht records
-			for {
-				select {
-				case <-s.closeCtx.Done():
-					log.Info().Str("source", "server").Msg("stopped dht updater")
-					return
-				case <-time.After(wait):
-				}
+		go s.dhtUpdater(dhtBackoff.withDefaults())
+	}
+	return s
+}
 
-				log.Debug().Str("source", "server").Msg("updating our dht record")
+// Close - stops dht updater and keepalive, closes all peer connections and cancels running handlers.
+// New inbound queries are rejected after close. Gateway is not closed, it is owned by the caller.
+// When session store is set, authenticated peers are saved to it before closing connections.
+func (s *Server) Close() error {
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.closer()
+	// queued queries will be processed with cancelled context, then workers exit
+	s.queryPool.Stop()
+
+	peers := make([]*PeerConnection, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	store := s.sessionStore
+	var persisted []PersistedSession
+	if store != nil {
+		persisted = s.persistedSessions()
+	}
+	s.mx.Unlock()
 
-				ctx, cancel := context.WithTimeout(s.closeCtx, 100*time.Second)
-				err := s.updateDHT(ctx)
-				cancel()
+	if store != nil {
+		store.Save(persisted)
+	}
 
-				if err != nil {
-					log.Warn().Err(err).Str("source", "server").Msg("failed to update our dht record, will retry in 5 sec")
+	// closed without lock, because disconnect handler takes it
+	for _, p := range peers {
+		p.adnl.Close()
+	}
+	return nil
+}
 
-					// on err, retry sooner
-					wait = 5 * time.Second
-					continue
-				}
-				wait = 1 * time.Minute
-			}
-		}()
+// Shutdown - closes server and waits for running handlers to finish, until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("handlers are not finished: %w", ctx.Err())
 	}
-	return s
 }
 
+// SetService - sets service which processes queries of parties, should be set before connections are accepted
 func (s *Server) SetService(svc Service) {
+	s.mx.Lock()
 	s.svc = svc
+	s.mx.Unlock()
+}
+
+// SetAuthorizer - sets hook which is called after successful authentication of peer,
+// when it returns error, connection with peer is closed
+func (s *Server) SetAuthorizer(authorizer Authorizer) {
+	s.mx.Lock()
+	s.authorizer = authorizer
+	s.mx.Unlock()
+}
+
+// SetQueryWorkers - configures how many inbound queries can be processed concurrently,
+// and how many can wait in queue. Queries which are not fit into the queue are rejected with ErrBusy decision,
+// or dropped when query type has no decision. Time in queue is counted in handle timeout.
+func (s *Server) SetQueryWorkers(workers, queueSize int) {
+	pool := newWorkerPool(workers, queueSize)
+
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		pool.Stop()
+		return
+	}
+	old := s.queryPool
+	s.queryPool = pool
+	s.mx.Unlock()
+
+	old.Stop()
+}
+
+// SetTimeouts - overrides network timings, zero fields are set to DefaultTimeouts values
+func (s *Server) SetTimeouts(t Timeouts) {
+	if t.QueryTimeout <= 0 {
+		t.QueryTimeout = DefaultTimeouts.QueryTimeout
+	}
+	if t.HandleTimeout <= 0 {
+		t.HandleTimeout = DefaultTimeouts.HandleTimeout
+	}
+	if t.DropPeerAfter <= 0 {
+		t.DropPeerAfter = DefaultTimeouts.DropPeerAfter
+	}
+	if t.ConnectTimeout <= 0 {
+		t.ConnectTimeout = DefaultTimeouts.ConnectTimeout
+	}
+	if t.ConnectAddressTimeout <= 0 {
+		t.ConnectAddressTimeout = DefaultTimeouts.ConnectAddressTimeout
+	}
+	if t.AuthMaxAge <= 0 {
+		t.AuthMaxAge = DefaultTimeouts.AuthMaxAge
+	}
+	if t.AuthMaxSkew <= 0 {
+		t.AuthMaxSkew = DefaultTimeouts.AuthMaxSkew
+	}
+	if t.MinColdQueryTime <= 0 {
+		t.MinColdQueryTime = DefaultTimeouts.MinColdQueryTime
+	}
+
+	s.mx.Lock()
+	s.timeouts = t
+	s.mx.Unlock()
 }
 
+func (s *Server) getTimeouts() Timeouts {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.timeouts
+}
+
+// SetMaxConcurrentConnects - limits number of connections which can be established at the same time,
+// others will wait for a free slot. Already connected peers are not counted. Zero or less means no limit.
+func (s *Server) SetMaxConcurrentConnects(n int) {
+	var sem chan struct{}
+	if n > 0 {
+		sem = make(chan struct{}, n)
+	}
+
+	s.mx.Lock()
+	s.connectSem = sem
+	s.mx.Unlock()
+}
+
+// acquireConnectSlot - waits for a free connection establishment slot, returned func must be called to free it
+func (s *Server) acquireConnectSlot(ctx context.Context) (func(), error) {
+	s.mx.RLock()
+	sem := s.connectSem
+	s.mx.RUnlock()
+
+	if sem == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to wait for free connect slot: %w", ctx.Err())
+	}
+}
+
+// errNoAddresses - gateway is not listening yet, so there is nothing to publish
+var errNoAddresses = errors.New("gateway has no addresses")
+
+const (
+	// _DHTUpdateTimeout - max time of the whole dht record update
+	_DHTUpdateTimeout = 100 * time.Second
+	// _DHTStoreTimeout - max time of one store operation during update
+	_DHTStoreTimeout = 40 * time.Second
+	// _DHTFindTimeout - max time to check that our address is stored
+	_DHTFindTimeout = 20 * time.Second
+)
+
 func (s *Server) updateDHT(ctx context.Context) error {
 	addr := s.gate.GetAddressList()
+	if len(addr.Addresses) == 0 {
+		// record without addresses is useless for peers, try again on the next cycle
+		return errNoAddresses
+	}
 
-	ctxStore, cancel := context.WithTimeout(ctx, 80*time.Second)
-	stored, id, err := s.dht.StoreAddress(ctxStore, addr, 10*time.Minute, s.key, 5)
+	// each step has its own timeout, so one hung dht node cannot block the whole update,
+	// and all of them are derived from ctx, so server close aborts them immediately
+	ctxStore, cancel := context.WithTimeout(ctx, _DHTStoreTimeout)
+	stored, id, err := s.dht.StoreAddress(ctxStore, addr, _DHTRecordTTL, s.key, 5)
 	cancel()
 	if err != nil && stored == 0 {
-		return err
+		return fmt.Errorf("failed to store our address in dht: %w", err)
 	}
 
 	// make sure it was saved
-	_, _, err = s.dht.FindAddresses(ctx, id)
+	ctxFind, cancel := context.WithTimeout(ctx, _DHTFindTimeout)
+	_, _, err = s.dht.FindAddresses(ctxFind, id)
+	cancel()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to find our stored address in dht: %w", err)
 	}
 	log.Debug().Str("source", "server").Int("copies", stored).Msg("our address was updated in dht")
 
@@ -126,8 +550,10 @@ func (s *Server) updateDHT(ctx context.Context) error {
 		return err
 	}
 
-	stored, _, err = s.dht.Store(ctx, chanKey, []byte("payment-node"), 0,
-		dhtVal, dht.UpdateRuleSignature{}, 10*time.Minute, s.channelKey, 5)
+	ctxStore, cancel = context.WithTimeout(ctx, _DHTStoreTimeout)
+	stored, _, err = s.dht.Store(ctxStore, chanKey, []byte("payment-node"), 0,
+		dhtVal, dht.UpdateRuleSignature{}, _DHTRecordTTL, s.channelKey, 5)
+	cancel()
 	if err != nil {
 		return fmt.Errorf("failed to store node payment-node value in dht: %w", err)
 	}
@@ -137,22 +563,68 @@ func (s *Server) updateDHT(ctx context.Context) error {
 }
 
 func (s *Server) bootstrapPeerWrap(client adnl.Peer) error {
-	s.bootstrapPeer(client)
+	if _, err := s.addPeer(client, true); err != nil {
+		// handler is called while gateway processes packet of this connection,
+		// closing it synchronously blocks the gateway, so we close it in background
+		go client.Close()
+	}
 	return nil
 }
 
 func (s *Server) bootstrapPeer(client adnl.Peer) *PeerConnection {
+	// outbound connections are not refused
+	p, _ := s.addPeer(client, false)
+	return p
+}
+
+func (s *Server) addPeer(client adnl.Peer, inbound bool) (*PeerConnection, error) {
+	var victim *PeerConnection
+	var rejectReason error
+	var rejectAddr string
+	var onRejected func(reason error, addr string)
+	defer func() {
+		// closed after unlock, because disconnect handler takes the lock
+		if victim != nil {
+			victim.adnl.Close()
+		}
+		if rejectReason != nil && onRejected != nil {
+			onRejected(rejectReason, rejectAddr)
+		}
+	}()
+
 	s.mx.Lock()
 	defer s.mx.Unlock()
 
 	if rl := s.peers[string(client.GetID())]; rl != nil {
-		return rl
+		return rl, nil
+	}
+	onRejected = s.onConnectionRejected
+
+	ip := remoteIP(client.RemoteAddr())
+	ipLimiter, err := s.acquireIP(ip, inbound)
+	if err != nil {
+		log.Debug().Err(err).Str("source", "server").Str("addr", client.RemoteAddr()).Msg("inbound connection refused")
+		rejectReason, rejectAddr = err, client.RemoteAddr()
+		return nil, err
+	}
+	victim = s.evictionVictim()
+	if victim != nil {
+		rejectReason, rejectAddr = ErrPeerEvicted, victim.adnl.RemoteAddr()
 	}
 
-	rl := rldp.NewClientV2(client)
+	guard := &sizeGuardADNL{ADNL: client, limit: s.messageSizeLimit}
+	rl := rldp.NewClientV2(guard)
 	p := &PeerConnection{
 		rldp: rl,
 		adnl: client,
+		stop: make(chan struct{}),
+
+		connectedAt: time.Now(),
+
+		anonLimiter: newTokenBucket(s.anonLimit),
+		authLimiter: newTokenBucket(s.authLimit),
+		ip:          ip,
+		ipLimiter:   ipLimiter,
 	}
 
 	rl.SetOnQuery(s.handleRLDPQuery(p))
@@ -160,138 +632,599 @@ func (s *Server) bootstrapPeer(client adnl.Peer) *PeerConnection {
 	rl.SetOnDisconnect(func() {
 		s.mx.Lock()
 		if p.authKey != nil {
-			log.Info().Hex("key", p.authKey).Msg("peer disconnected")
+			s.peerLog(log.Info(), p.authKey).Msg("peer disconnected")
 
-			delete(s.peersByKey, string(p.authKey))
+			// key can be already bound to another connection
+			if s.peersByKey[string(p.authKey)] == p {
+				delete(s.peersByKey, string(p.authKey))
+				s.expireSession(p)
+			}
 		}
 		delete(s.peers, string(p.adnl.GetID()))
+		s.releaseIP(p.ip)
+		metrics := s.metrics
 		s.mx.Unlock()
+
+		metrics.IncPeer(-1)
+		close(p.stop)
 	})
+	guard.start()
 
+	p.touch()
 	s.peers[string(client.GetID())] = p
+	s.metrics.IncPeer(1)
 
-	return p
+	if s.keepaliveInterval > 0 {
+		go s.keepalive(p, s.keepaliveInterval, s.keepaliveMaxMissed)
+	}
+
+	return p, nil
 }
 
 func (s *Server) handleRLDPQuery(peer *PeerConnection) func(transfer []byte, query *rldp.Query) error {
 	return func(transfer []byte, query *rldp.Query) error {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+		// we hold read lock during submit, to not race with pool replacement and close
+		s.mx.RLock()
+		if s.closed {
+			s.mx.RUnlock()
+			return fmt.Errorf("server is closed")
+		}
 
-		switch q := query.Data.(type) {
-		case Authenticate:
-			if q.Timestamp < time.Now().Add(-30*time.Second).Unix() || q.Timestamp > time.Now().Unix() {
-				return fmt.Errorf("outdated auth data")
-			}
+		if q, ok := query.Data.(Ping); ok {
+			s.mx.RUnlock()
+			// keepalive is answered inline, bypassing limits and queue,
+			// so a healthy but busy peer is not closed because of missed pongs
+			return s.answerPing(peer, transfer, query, q)
+		}
 
-			// check signature with both adnl addresses, to protect from MITM attack
-			authData, err := tl.Hash(AuthenticateToSign{
-				A:         peer.adnl.GetID(),
-				B:         s.gate.GetID(),
-				Timestamp: q.Timestamp,
-			})
-			if err != nil {
-				return fmt.Errorf("failed to hash their auth data: %w", err)
-			}
+		limiter := peer.anonLimiter
+		if peer.authKey != nil {
+			limiter = peer.authLimiter
+		}
+		now := time.Now()
+		if !limiter.allow(now) || !peer.ipLimiter.allow(now) {
+			s.mx.RUnlock()
+			return s.rejectQuery(peer, transfer, query, ErrRateLimited)
+		}
 
-			if !ed25519.Verify(q.Key, authData, q.Signature) {
-				return fmt.Errorf("incorrect signature")
-			}
+		peer.touch()
+
+		atomic.AddInt32(&peer.activeTransfers, 1)
+		s.handlers.Add(1)
+		ok := s.queryPool.Submit(func() {
+			defer s.handlers.Done()
+			defer atomic.AddInt32(&peer.activeTransfers, -1)
+
+			tm := time.Now()
+			err := s.processQuery(peer, transfer, query, now)
+			s.observeHandle(queryKind(query.Data), time.Since(tm), err)
 
-			s.mx.Lock()
-			if peer.authKey != nil {
-				// when authenticated with new key, delete old record
-				delete(s.peersByKey, string(peer.authKey))
-			}
-			peer.authKey = append([]byte{}, q.Key...)
-			s.peersByKey[string(peer.authKey)] = peer
-			s.mx.Unlock()
-			log.Info().Hex("key", peer.authKey).Msg("connected with peer")
-
-			// reverse A and B, and sign, so party can verify us too
-			authData, err = tl.Hash(AuthenticateToSign{
-				A:         s.gate.GetID(),
-				B:         peer.adnl.GetID(),
-				Timestamp: q.Timestamp,
-			})
 			if err != nil {
-				return fmt.Errorf("failed to hash our auth data: %w", err)
+				if errors.Is(err, ErrInternal) {
+					log.Error().Err(err).Str("source", "server").Msg("failed to process query")
+					return
+				}
+				log.Debug().Err(err).Str("source", "server").Msg("failed to process query")
+				return
 			}
 
-			if err = peer.rldp.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, Authenticate{
-				Key:       s.channelKey.Public().(ed25519.PublicKey),
-				Timestamp: q.Timestamp,
-				Signature: ed25519.Sign(s.channelKey, authData),
-			}); err != nil {
-				return err
-			}
-		case GetChannelConfig:
-			if err := peer.rldp.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, s.svc.GetChannelConfig()); err != nil {
-				return err
+			peer.markSuccess()
+		})
+		s.mx.RUnlock()
+
+		if !ok {
+			s.handlers.Done()
+			atomic.AddInt32(&peer.activeTransfers, -1)
+			log.Warn().Str("source", "server").Type("query", query.Data).Msg("query queue is full, rejecting query")
+
+			return s.rejectQuery(peer, transfer, query, ErrBusy)
+		}
+		return nil
+	}
+}
+
+// answerPing - sends pong for keepalive ping
+func (s *Server) answerPing(peer *PeerConnection, transfer []byte, query *rldp.Query, ping Ping) error {
+	ctx, cancel := context.WithTimeout(s.closeCtx, s.getTimeouts().HandleTimeout)
+	defer cancel()
+
+	tm := time.Now()
+	err := s.sendAnswer(ctx, peer, transfer, query, Pong{Timestamp: ping.Timestamp})
+	s.observeHandle(queryKind(query.Data), time.Since(tm), err)
+	if err != nil {
+		return fmt.Errorf("failed to send pong: %w", err)
+	}
+	return nil
+}
+
+// rejectQuery - answers with rejection without processing the query, when it has decision answer
+func (s *Server) rejectQuery(peer *PeerConnection, transfer []byte, query *rldp.Query, reject error) error {
+	s.observeHandle(queryKind(query.Data), 0, reject)
+	log.Debug().Err(reject).Str("source", "server").Type("query", query.Data).Msg("query is rejected without processing")
+
+	answer := rejectionAnswer(query.Data, reject)
+	if answer == nil {
+		return reject
+	}
+
+	ctx, cancel := context.WithTimeout(s.closeCtx, s.getTimeouts().HandleTimeout)
+	defer cancel()
+
+	if err := s.sendAnswer(ctx, peer, transfer, query, answer); err != nil {
+		return fmt.Errorf("failed to send rejection: %w", err)
+	}
+	return reject
+}
+
+// fitAnswer - returns answer if it fits into max answer size of the query, otherwise returns tooLarge,
+// so the caller receives explicit rejection instead of waiting for timeout.
+func fitAnswer(query *rldp.Query, answer, tooLarge tl.Serializable) (tl.Serializable, error) {
+	data, err := tl.Serialize(rldp.Answer{ID: query.ID, Data: answer}, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize answer: %w", err)
+	}
+
+	if int64(len(data)) <= query.MaxAnswerSize {
+		return answer, nil
+	}
+
+	log.Warn().Str("source", "server").Type("answer", answer).Int("size", len(data)).
+		Int64("max_size", query.MaxAnswerSize).Msg("answer is too large, responding with rejection")
+	return tooLarge, nil
+}
+
+// sendAnswer - answers inbound query, when answer is larger than party accepts, rejection is sent instead
+func (s *Server) sendAnswer(ctx context.Context, peer *PeerConnection, transfer []byte, query *rldp.Query, answer tl.Serializable) error {
+	s.tracePayload(peer, nil, "inbound answer", answer)
+
+	err := peer.rldp.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, answer)
+	if err == nil {
+		return nil
+	}
+
+	if tooLarge, _ := tooLargeAnswer(query, answer); tooLarge {
+		log.Warn().Str("source", "server").Type("answer", answer).Int64("max_size", query.MaxAnswerSize).
+			Msg("answer is too large, responding with rejection")
+
+		if err = peer.rldp.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer,
+			Decision{Agreed: false, Reason: ErrAnswerTooLarge.Error()}); err != nil {
+			return err
+		}
+		return ErrAnswerTooLarge
+	}
+	return err
+}
+
+// processQuery - handles inbound query, handle timeout is counted from received time, including time in queue
+func (s *Server) processQuery(peer *PeerConnection, transfer []byte, query *rldp.Query, received time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Str("source", "server").Type("query", query.Data).
+				Str("stack", string(debug.Stack())).Msgf("panic during query processing: %v", r)
+			err = fmt.Errorf("%w: panic during query processing: %v", ErrInternal, r)
+		}
+	}()
+
+	// handler is aborted when server is closed
+	ctx, cancel := context.WithDeadline(s.closeCtx, received.Add(s.handleTimeout(peer)))
+	defer cancel()
+
+	if ctx.Err() != nil {
+		// party is not waiting for the answer anymore
+		return fmt.Errorf("query is expired in queue: %w", ctx.Err())
+	}
+
+	s.tracePayload(peer, nil, "inbound query", query.Data)
+
+	switch query.Data.(type) {
+	case Authenticate:
+	default:
+		if peer.authKey == nil {
+			// party could resume its session without handshake
+			peer.mx.Lock()
+			if peer.authKey == nil {
+				s.resumeSession(ctx, peer, true)
 			}
-		case RequestInboundChannel:
-			res := Decision{Agreed: true}
-			err := s.svc.ProcessInboundChannelRequest(ctx, new(big.Int).SetBytes(q.Capacity), address.NewAddress(0, 0, q.Wallet), q.Key)
-			if err != nil {
-				res.Agreed = false
-				res.Reason = err.Error()
+			peer.mx.Unlock()
+		}
+	}
+
+	switch q := query.Data.(type) {
+	case Authenticate:
+		if bytes.Equal(q.Key, s.channelKey.Public().(ed25519.PublicKey)) {
+			// loopback or someone else uses our key, binding it will break routing to ourself
+			return fmt.Errorf("peer authenticates with our own channel key")
+		}
+
+		if err := checkAuthTimestamp(q.Timestamp, time.Now(), s.getTimeouts()); err != nil {
+			return err
+		}
+
+		if err := checkAuthAlgorithm(q.Algorithm); err != nil {
+			return err
+		}
+
+		algorithm, err := selectAuthAlgorithm(q.Supported)
+		if err != nil {
+			return err
+		}
+
+		// check signature with both adnl addresses, to protect from MITM attack
+		authData, err := authDigest(peer.adnl.GetID(), s.gate.GetID(), q.Timestamp, q.Algorithm)
+		if err != nil {
+			return fmt.Errorf("failed to hash their auth data: %w", err)
+		}
+
+		if err = verifyAuth(&q, authData); err != nil {
+			return err
+		}
+
+		if err = s.authorize(ctx, peer, q.Key); err != nil {
+			return err
+		}
+
+		s.bindKey(peer, q.Key)
+		s.saveSession(peer, q)
+		s.checkDowngrade(&q)
+		s.peerLog(log.Info(), q.Key).Msg("connected with peer")
+		s.notifyAuthenticated(q.Key, true)
+
+		// reverse A and B, and sign, so party can verify us too
+		authData, err = authDigest(s.gate.GetID(), peer.adnl.GetID(), q.Timestamp, algorithm)
+		if err != nil {
+			return fmt.Errorf("failed to hash our auth data: %w", err)
+		}
+
+		res, err := s.signAuth(algorithm, authData, q.Timestamp)
+		if err != nil {
+			return fmt.Errorf("failed to sign our auth data: %w", err)
+		}
+
+		if err = s.sendAnswer(ctx, peer, transfer, query, res); err != nil {
+			return err
+		}
+	case GetChannelConfig:
+		if err := s.sendAnswer(ctx, peer, transfer, query, s.respCache.get(queryKind(q), func() tl.Serializable {
+			return s.svc.GetChannelConfig()
+		})); err != nil {
+			return err
+		}
+	case GetFeeSchedule:
+		if err := s.sendAnswer(ctx, peer, transfer, query, s.respCache.get(queryKind(q), func() tl.Serializable {
+			return s.svc.GetFeeSchedule()
+		})); err != nil {
+			return err
+		}
+	case GetSharedChannels:
+		if peer.authKey == nil {
+			return fmt.Errorf("not authorized")
+		}
+
+		list, err := s.svc.GetSharedChannels(ctx, peer.authKey)
+		if err != nil {
+			return fmt.Errorf("failed to get shared channels: %w", err)
+		}
+
+		answer, err := fitAnswer(query, SharedChannels{List: list}, SharedChannels{})
+		if err != nil {
+			return err
+		}
+
+		if err = s.sendAnswer(ctx, peer, transfer, query, answer); err != nil {
+			return err
+		}
+	case GetChannelState:
+		if peer.authKey == nil {
+			return fmt.Errorf("not authorized")
+		}
+
+		channelAddr, err := s.getAddressCodec().ChannelAddress(q.ChannelWorkchain, q.ChannelAddr)
+		if err != nil {
+			return fmt.Errorf("failed to parse channel address: %w", err)
+		}
+
+		state, err := s.svc.GetChannelState(ctx, peer.authKey, channelAddr)
+		if err != nil {
+			return fmt.Errorf("failed to get channel state: %w", err)
+		}
+
+		stateCell, err := tlb.ToCell(state)
+		if err != nil {
+			return fmt.Errorf("failed to serialize state cell: %w", err)
+		}
+
+		if err = s.sendAnswer(ctx, peer, transfer, query, ChannelState{SignedState: stateCell}); err != nil {
+			return err
+		}
+	case GetBalanceAttestation:
+		if peer.authKey == nil {
+			return fmt.Errorf("not authorized")
+		}
+
+		channelAddr, err := s.getAddressCodec().ChannelAddress(q.ChannelWorkchain, q.ChannelAddr)
+		if err != nil {
+			return fmt.Errorf("failed to parse channel address: %w", err)
+		}
+
+		balance, err := s.svc.GetBalanceAttestation(ctx, peer.authKey, channelAddr)
+		if err != nil {
+			return fmt.Errorf("failed to get balance: %w", err)
+		}
+
+		res, err := signBalanceAttestation(s.channelKey, channelAddr, balance, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to sign balance attestation: %w", err)
+		}
+
+		if err = s.sendAnswer(ctx, peer, transfer, query, res); err != nil {
+			return err
+		}
+	case NegotiateSettlement:
+		if peer.authKey == nil {
+			return fmt.Errorf("not authorized")
+		}
+
+		res := Decision{Agreed: true}
+		if q.Interval < 0 {
+			err = fmt.Errorf("negative settlement interval")
+		} else {
+			err = s.svc.NegotiateSettlement(ctx, peer.authKey, time.Duration(q.Interval)*time.Second, new(big.Int).SetBytes(q.Threshold))
+		}
+		if err != nil {
+			res.Agreed = false
+			res.Reason = err.Error()
+		}
+
+		if err = s.sendAnswer(ctx, peer, transfer, query, res); err != nil {
+			return err
+		}
+	case ConfirmClose:
+		if peer.authKey == nil {
+			return fmt.Errorf("not authorized")
+		}
+
+		res := Decision{Agreed: true}
+		channelAddr, err := s.getAddressCodec().ChannelAddress(q.ChannelWorkchain, q.ChannelAddr)
+		if err != nil {
+			err = fmt.Errorf("failed to parse channel address: %w", err)
+		} else {
+			err = s.svc.ProcessCloseConfirmation(ctx, peer.authKey, channelAddr, q.TxHash)
+		}
+		if err != nil {
+			res.Agreed = false
+			res.Reason = err.Error()
+		}
+
+		if err = s.sendAnswer(ctx, peer, transfer, query, res); err != nil {
+			return err
+		}
+	case RequestInboundChannel:
+		codec := s.getAddressCodec()
+		walletAddr, err := codec.WalletAddress(q.WalletWorkchain, q.Wallet)
+		if err == nil {
+			var jettonMaster *address.Address
+			if jettonMaster, err = codec.JettonMasterAddress(q.JettonMaster); err != nil {
+				err = fmt.Errorf("failed to parse jetton master address: %w", err)
+			} else {
+				err = s.svc.ProcessInboundChannelRequest(ctx, new(big.Int).SetBytes(q.Capacity), jettonMaster, walletAddr, q.Key)
 			}
+		}
+
+		if err = s.sendAnswer(ctx, peer, transfer, query, inboundDecision(err)); err != nil {
+			return err
+		}
+	case ProposeAction:
+		if peer.authKey == nil {
+			return fmt.Errorf("not authorized")
+		}
+
+		channelAddr, err := s.getAddressCodec().ChannelAddress(q.ChannelWorkchain, q.ChannelAddr)
+		if err != nil {
+			// reject explicitly, so party knows that address is not supported
+			return s.sendAnswer(ctx, peer, transfer, query,
+				ProposalDecision{Agreed: false, Reason: "failed to parse channel address: " + err.Error()})
+		}
+
+		var state payments.SignedSemiChannel
+		if err := tlb.LoadFromCell(&state, q.SignedState.BeginParse()); err != nil {
+			return fmt.Errorf("failed to parse channel state")
+		}
+
+		var updCell *cell.Cell
+		ok := true
+		reason := ""
 
-			if err = peer.rldp.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, res); err != nil {
-				return err
+		var updateProof *payments.SignedSemiChannel
+		release, err := s.acquireSeqnos([]seqnoProposal{{channelAddr.String(), state.State.Data.Seqno}})
+		if err == nil {
+			updateProof, err = s.svc.ProcessAction(ctx, peer.authKey, channelAddr, state, q.Action)
+			release(err == nil)
+		}
+		if err != nil {
+			reason = err.Error()
+			ok = false
+		} else {
+			if updCell, err = tlb.ToCell(updateProof); err != nil {
+				return fmt.Errorf("failed to serialize state cell: %w", err)
 			}
-		case ProposeAction:
-			if peer.authKey == nil {
-				return fmt.Errorf("not authorized")
+		}
+
+		answer, err := fitAnswer(query, ProposalDecision{Agreed: ok, Reason: reason, SignedState: updCell},
+			ProposalDecision{Agreed: false, Reason: ErrAnswerTooLarge.Error()})
+		if err != nil {
+			return err
+		}
+
+		if err = s.sendAnswer(ctx, peer, transfer, query, answer); err != nil {
+			return err
+		}
+	case ProposeActions:
+		if peer.authKey == nil {
+			return fmt.Errorf("not authorized")
+		}
+
+		var err error
+		codec := s.getAddressCodec()
+		proposals := make([]ActionProposal, 0, len(q.Actions))
+		for i, a := range q.Actions {
+			channelAddr, addrErr := codec.ChannelAddress(a.ChannelWorkchain, a.ChannelAddr)
+			if addrErr != nil {
+				// whole batch is rejected
+				err = fmt.Errorf("action %d: failed to parse channel address: %w", i, addrErr)
+				break
 			}
 
 			var state payments.SignedSemiChannel
-			if err := tlb.LoadFromCell(&state, q.SignedState.BeginParse()); err != nil {
-				return fmt.Errorf("failed to parse channel state")
+			if err := tlb.LoadFromCell(&state, a.SignedState.BeginParse()); err != nil {
+				return fmt.Errorf("failed to parse channel state of action %d", i)
 			}
 
-			var updCell *cell.Cell
-			ok := true
-			reason := ""
-			updateProof, err := s.svc.ProcessAction(ctx, peer.authKey,
-				address.NewAddress(0, 0, q.ChannelAddr), state, q.Action)
-			if err != nil {
-				reason = err.Error()
-				ok = false
-			} else {
-				if updCell, err = tlb.ToCell(updateProof); err != nil {
-					return fmt.Errorf("failed to serialize state cell: %w", err)
-				}
+			proposals = append(proposals, ActionProposal{
+				ChannelAddr: channelAddr,
+				SignedState: state,
+				Action:      a.Action,
+			})
+		}
+
+		res := ProposalDecisions{List: make([]ProposalDecision, len(q.Actions))}
+
+		var updateProofs []*payments.SignedSemiChannel
+		if err == nil {
+			seqnos := make([]seqnoProposal, 0, len(proposals))
+			for _, p := range proposals {
+				seqnos = append(seqnos, seqnoProposal{p.ChannelAddr.String(), p.SignedState.State.Data.Seqno})
 			}
 
-			if err := peer.rldp.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, ProposalDecision{Agreed: ok, Reason: reason, SignedState: updCell}); err != nil {
-				return err
+			var release func(accepted bool)
+			if release, err = s.acquireSeqnos(seqnos); err == nil {
+				updateProofs, err = s.svc.ProcessActions(ctx, peer.authKey, proposals)
+				release(err == nil)
 			}
-		case RequestAction:
-			if peer.authKey == nil {
-				return fmt.Errorf("not authorized")
+		}
+		if err != nil {
+			for i := range res.List {
+				res.List[i] = ProposalDecision{Agreed: false, Reason: err.Error()}
 			}
-
-			ok := true
-			reason := ""
-			if err := s.svc.ProcessActionRequest(ctx, peer.authKey,
-				address.NewAddress(0, 0, q.ChannelAddr), q.Action); err != nil {
-				reason = err.Error()
-				ok = false
+		} else {
+			for i, proof := range updateProofs {
+				updCell, err := tlb.ToCell(proof)
+				if err != nil {
+					return fmt.Errorf("failed to serialize state cell: %w", err)
+				}
+				res.List[i] = ProposalDecision{Agreed: true, SignedState: updCell}
 			}
+		}
 
-			if err := peer.rldp.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, Decision{Agreed: ok, Reason: reason}); err != nil {
-				return err
-			}
+		tooLarge := ProposalDecisions{List: make([]ProposalDecision, len(res.List))}
+		for i := range tooLarge.List {
+			tooLarge.List[i] = ProposalDecision{Agreed: false, Reason: ErrAnswerTooLarge.Error()}
 		}
-		return nil
+
+		answer, err := fitAnswer(query, res, tooLarge)
+		if err != nil {
+			return err
+		}
+
+		if err = s.sendAnswer(ctx, peer, transfer, query, answer); err != nil {
+			return err
+		}
+	case RequestAction:
+		if peer.authKey == nil {
+			return fmt.Errorf("not authorized")
+		}
+
+		res := Decision{Agreed: true}
+
+		var baseStateHash []byte
+		channelAddr, err := s.getAddressCodec().ChannelAddress(q.ChannelWorkchain, q.ChannelAddr)
+		if err != nil {
+			err = fmt.Errorf("failed to parse channel address: %w", err)
+		} else {
+			baseStateHash, err = s.svc.ProcessActionRequest(ctx, peer.authKey, channelAddr, q.Action)
+		}
+		if err != nil {
+			res.Agreed = false
+			res.Reason = err.Error()
+		} else if len(baseStateHash) == 32 {
+			res.Flags |= 1
+			res.BaseStateHash = baseStateHash
+		}
+
+		if err := s.sendAnswer(ctx, peer, transfer, query, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAuthTimestamp - validates that auth request is not too old and not too far in the future
+func checkAuthTimestamp(ts int64, now time.Time, t Timeouts) error {
+	delta := time.Duration(ts-now.Unix()) * time.Second
+	if delta < -t.AuthMaxAge || delta > t.AuthMaxSkew {
+		log.Debug().Str("source", "server").Dur("delta", delta).Msg("auth timestamp is out of allowed window, check clock sync")
+		return fmt.Errorf("outdated auth data, timestamp delta %s", delta)
+	}
+	return nil
+}
+
+// authDigest - hash of AuthenticateToSign, A and B are adnl ids of signer and verifier
+func authDigest(a, b []byte, ts int64, algorithm int32) ([]byte, error) {
+	hash, err := tl.Hash(AuthenticateToSign{
+		A:         a,
+		B:         b,
+		Timestamp: ts,
+		Algorithm: algorithm,
+	})
+	if err != nil {
+		// should never happen, types are always serializable
+		return nil, fmt.Errorf("%w: failed to serialize auth data: %s", ErrInternal, err.Error())
 	}
+	return hash, nil
 }
 
 func (s *Server) connect(ctx context.Context, channelKey ed25519.PublicKey) (*PeerConnection, error) {
+	if node, ok := s.getStaticPeer(channelKey); ok {
+		peer, err := s.connectAddresses(ctx, node.key, node.addrs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to static peer of %s: %w", hex.EncodeToString(channelKey), err)
+		}
+		return peer, nil
+	}
+
+	if node, ok := s.dhtCache.get(channelKey); ok {
+		peer, err := s.connectAddresses(ctx, node.key, node.addrs)
+		if err == nil {
+			return peer, nil
+		}
+
+		// node could change its address, so we resolve it again
+		s.peerLog(log.Debug().Err(err).Str("source", "server"), channelKey).Msg("failed to connect using cached addresses, resolving again")
+		s.dhtCache.invalidate(channelKey)
+	}
+
+	node, err := s.resolve(ctx, channelKey)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := s.connectAddresses(ctx, node.key, node.addrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to peer of %s: %w", hex.EncodeToString(channelKey), err)
+	}
+
+	s.dhtCache.put(channelKey, node)
+	return peer, nil
+}
+
+// resolve - finds node's adnl address and its ips in dht, using channel key
+func (s *Server) resolve(ctx context.Context, channelKey ed25519.PublicKey) (resolvedNode, error) {
+	if s.dht == nil {
+		return resolvedNode{}, fmt.Errorf("dht is not configured, cannot resolve %s", hex.EncodeToString(channelKey))
+	}
+
 	channelKeyId, err := tl.Hash(adnl.PublicKeyED25519{Key: channelKey})
 	if err != nil {
-		return nil, fmt.Errorf("failed to calc hash of channel key %s: %w", hex.EncodeToString(channelKey), err)
+		return resolvedNode{}, fmt.Errorf("failed to calc hash of channel key %s: %w", hex.EncodeToString(channelKey), err)
 	}
 
 	dhtVal, _, err := s.dht.FindValue(ctx, &dht.Key{
@@ -300,75 +1233,230 @@ func (s *Server) connect(ctx context.Context, channelKey ed25519.PublicKey) (*Pe
 		Index: 0,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to find address in dht of %s: %w", hex.EncodeToString(channelKey), err)
+		return resolvedNode{}, fmt.Errorf("failed to find address in dht of %s: %w", hex.EncodeToString(channelKey), err)
 	}
 
 	var nodeAddr NodeAddress
 	if _, err = tl.Parse(&nodeAddr, dhtVal.Data, true); err != nil {
-		return nil, fmt.Errorf("failed to parse node dht value of %s: %w", hex.EncodeToString(channelKey), err)
+		return resolvedNode{}, fmt.Errorf("failed to parse node dht value of %s: %w", hex.EncodeToString(channelKey), err)
 	}
 
 	list, key, err := s.dht.FindAddresses(ctx, nodeAddr.ADNLAddr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find address in dht of %s: %w", hex.EncodeToString(channelKey), err)
+		return resolvedNode{}, fmt.Errorf("failed to find address in dht of %s: %w", hex.EncodeToString(channelKey), err)
 	}
 
 	if len(list.Addresses) == 0 {
-		return nil, fmt.Errorf("no addresses for %s", hex.EncodeToString(channelKey))
+		return resolvedNode{}, fmt.Errorf("no addresses for %s", hex.EncodeToString(channelKey))
 	}
-	addr := fmt.Sprintf("%s:%d", list.Addresses[0].IP.String(), list.Addresses[0].Port)
 
-	peer, err := s.gate.RegisterClient(addr, key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to peer of %s at %s: %w", hex.EncodeToString(channelKey), addr, err)
+	addrs := make([]string, 0, len(list.Addresses))
+	for _, a := range list.Addresses {
+		addrs = append(addrs, net.JoinHostPort(a.IP.String(), strconv.Itoa(int(a.Port))))
 	}
-	return s.bootstrapPeer(peer), nil
+	return resolvedNode{key: key, addrs: addrs}, nil
+}
+
+// connectAddresses - tries node's addresses one by one, until authentication succeeds using one of them.
+// Node can publish several addresses, and some of them can be stale or unreachable from our side.
+func (s *Server) connectAddresses(ctx context.Context, key ed25519.PublicKey, addrs []string) (*PeerConnection, error) {
+	timeout := s.getTimeouts().ConnectAddressTimeout
+
+	var errs addressErrors
+	var last *PeerConnection
+	for i, addr := range addrs {
+		client, err := s.gate.RegisterClient(addr, key)
+		if err != nil {
+			s.addrStats.add(addr, err)
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		peer := s.bootstrapPeer(client)
+		last = peer
+
+		s.mx.Lock()
+		peer.nodeKey = append(ed25519.PublicKey{}, key...)
+		s.mx.Unlock()
+
+		addrCtx, cancel := ctx, func() {}
+		if i < len(addrs)-1 {
+			addrCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		peer.mx.Lock()
+		if peer.authKey == nil && !s.resumeSession(addrCtx, peer, false) {
+			err = s.auth(addrCtx, peer)
+		}
+		peer.mx.Unlock()
+		cancel()
+
+		s.addrStats.add(addr, err)
+		if err == nil {
+			return peer, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// same adnl peer is reused for all addresses, so we close it only when all of them are failed
+	if last != nil {
+		last.adnl.Close()
+	}
+	return nil, fmt.Errorf("all addresses are failed: %w", errs)
+}
+
+// addressErrors - failures of node's addresses, each of them can be matched with errors.Is
+type addressErrors []error
+
+func (e addressErrors) Error() string {
+	list := make([]string, 0, len(e))
+	for _, err := range e {
+		list = append(list, err.Error())
+	}
+	return strings.Join(list, "; ")
+}
+
+func (e addressErrors) Unwrap() []error {
+	return e
 }
 
 func (s *Server) auth(ctx context.Context, peer *PeerConnection) error {
 	ts := time.Now().Unix()
-	authData, err := tl.Hash(AuthenticateToSign{
-		A:         s.gate.GetID(),
-		B:         peer.adnl.GetID(),
-		Timestamp: ts,
-	})
+	algorithm := supportedAuthAlgorithms[0]
+	authData, err := authDigest(s.gate.GetID(), peer.adnl.GetID(), ts, algorithm)
 	if err != nil {
 		return fmt.Errorf("failed to hash our auth data: %w", err)
 	}
 
+	req, err := s.signAuth(algorithm, authData, ts)
+	if err != nil {
+		return fmt.Errorf("failed to sign our auth data: %w", err)
+	}
+
 	var res Authenticate
-	err = peer.rldp.DoQuery(ctx, _RLDPMaxAnswerSize, Authenticate{
-		Key:       s.channelKey.Public().(ed25519.PublicKey),
-		Timestamp: ts,
-		Signature: ed25519.Sign(s.channelKey, authData),
-	}, &res)
+	tm := time.Now()
+	atomic.AddInt32(&peer.activeTransfers, 1)
+	err = doTypedQuery(ctx, peer.rldp, _RLDPMaxAnswerSize, req, &res)
+	atomic.AddInt32(&peer.activeTransfers, -1)
+	s.observeQuery(queryKind(req), time.Since(tm), err)
 	if err != nil {
 		return fmt.Errorf("failed to request auth: %w", err)
 	}
 
-	authData, err = tl.Hash(AuthenticateToSign{
-		A:         peer.adnl.GetID(),
-		B:         s.gate.GetID(),
-		Timestamp: ts,
-	})
+	// party should select one of algorithms we offered
+	if err = checkAuthAlgorithm(res.Algorithm); err != nil {
+		return fmt.Errorf("%w: incorrect response: %w", errAuthAttempt, err)
+	}
+
+	authData, err = authDigest(peer.adnl.GetID(), s.gate.GetID(), ts, res.Algorithm)
 	if err != nil {
 		return fmt.Errorf("failed to hash their auth data: %w", err)
 	}
 
-	if !ed25519.Verify(res.Key, authData, res.Signature) {
-		return fmt.Errorf("incorrect response signature")
+	if err = verifyAuth(&res, authData); err != nil {
+		return fmt.Errorf("%w: incorrect response: %w", errAuthAttempt, err)
 	}
 
+	if err = s.authorize(ctx, peer, res.Key); err != nil {
+		return fmt.Errorf("%w: %w", errAuthAttempt, err)
+	}
+
+	s.bindKey(peer, res.Key)
+	s.saveSession(peer, res)
+	s.checkDowngrade(&res)
+	s.peerLog(log.Info(), res.Key).Msg("connected with peer")
+	s.notifyAuthenticated(res.Key, false)
+
+	return nil
+}
+
+// authorize - checks authenticated key using authorizer, and drops peer if it is not allowed
+func (s *Server) authorize(ctx context.Context, peer *PeerConnection, key ed25519.PublicKey) error {
+	s.mx.RLock()
+	authorizer := s.authorizer
+	s.mx.RUnlock()
+
+	if authorizer == nil {
+		return nil
+	}
+
+	if err := authorizer.Authorize(ctx, key); err != nil {
+		s.peerLog(log.Info().Err(err), key).Msg("peer is not authorized, dropping connection")
+		peer.adnl.Close()
+		return fmt.Errorf("peer is not authorized: %w", err)
+	}
+	return nil
+}
+
+// bindKey - associates authenticated channel key with peer connection.
+// When key was bound to another connection, old connection is closed, because only one
+// connection per key is used, and hook is notified, since it can be a sign of key compromise.
+func (s *Server) bindKey(peer *PeerConnection, key ed25519.PublicKey) {
 	s.mx.Lock()
-	if peer.authKey != nil {
+	if peer.authKey != nil && s.peersByKey[string(peer.authKey)] == peer {
 		// when authenticated with new key, delete old record
 		delete(s.peersByKey, string(peer.authKey))
 	}
-	peer.authKey = append([]byte{}, res.Key...)
+	prev := s.peersByKey[string(key)]
+	peer.authKey = append([]byte{}, key...)
 	s.peersByKey[string(peer.authKey)] = peer
+	onRebind := s.onKeyRebind
+	s.mx.Unlock()
+
+	if prev == nil || prev == peer {
+		return
+	}
+
+	if bytes.Equal(prev.adnl.GetID(), peer.adnl.GetID()) {
+		// same node reconnected, old connection is stale
+		prev.adnl.Close()
+		return
+	}
+
+	s.peerLog(log.Warn().Str("source", "server"), key).Hex("old_adnl", prev.adnl.GetID()).
+		Hex("new_adnl", peer.adnl.GetID()).Msg("channel key is authenticated from another adnl address, closing old connection")
+
+	if onRebind != nil {
+		onRebind(append(ed25519.PublicKey{}, key...), prev.adnl.GetID(), peer.adnl.GetID())
+	}
+	prev.adnl.Close()
+}
+
+// SetOnPeerAuthenticated - sets hook which is called when peer is authenticated, inbound is true when
+// handshake was started by the peer. Hook is called from query processing goroutine, so it must not block.
+func (s *Server) SetOnPeerAuthenticated(f func(key ed25519.PublicKey, inbound bool)) {
+	s.mx.Lock()
+	s.onPeerAuthenticated = f
 	s.mx.Unlock()
-	log.Info().Hex("key", peer.authKey).Msg("connected with peer")
+}
 
+func (s *Server) notifyAuthenticated(key ed25519.PublicKey, inbound bool) {
+	s.mx.RLock()
+	hook := s.onPeerAuthenticated
+	s.mx.RUnlock()
+
+	if hook != nil {
+		hook(append(ed25519.PublicKey{}, key...), inbound)
+	}
+}
+
+// SetOnKeyRebind - sets hook which is called when channel key, already connected from one adnl address,
+// is authenticated from another one. Old connection is closed after the hook call.
+func (s *Server) SetOnKeyRebind(f func(channelKey ed25519.PublicKey, oldADNL, newADNL []byte)) {
+	s.mx.Lock()
+	s.onKeyRebind = f
+	s.mx.Unlock()
+}
+
+// Warmup - connects and authenticates with party in advance, so the first query will not wait for it.
+// Does nothing when party is already authenticated.
+func (s *Server) Warmup(ctx context.Context, channelKey ed25519.PublicKey) error {
+	if _, err := s.preparePeer(ctx, channelKey); err != nil {
+		return fmt.Errorf("failed to warmup connection: %w", err)
+	}
 	return nil
 }
 
@@ -382,18 +1470,24 @@ func (s *Server) preparePeer(ctx context.Context, key []byte) (peer *PeerConnect
 	s.mx.RUnlock()
 
 	if peer == nil {
-		if peer, err = s.connect(ctx, key); err != nil {
-			return nil, fmt.Errorf("failed to connect to peer: %w", err)
+		if peer, err = s.connectShared(ctx, key); err != nil {
+			return nil, err
 		}
 	}
 
 	peer.mx.Lock()
 	defer peer.mx.Unlock()
 
-	if peer.authKey == nil {
+	if peer.authKey == nil && !s.resumeSession(ctx, peer, false) {
+		if err = s.checkAuthBackoff(key); err != nil {
+			return nil, err
+		}
+
 		if err = s.auth(ctx, peer); err != nil {
-			return nil, fmt.Errorf("failed to auth peer: %w", err)
+			s.authFailed(key, err)
+			return nil, fmt.Errorf("%w: %w", ErrAuthFailed, err)
 		}
+		s.authSucceeded(key)
 	}
 
 	return peer, nil
@@ -408,66 +1502,274 @@ func (s *Server) GetChannelConfig(ctx context.Context, theirChannelKey ed25519.P
 	return &res, nil
 }
 
+func (s *Server) GetFeeSchedule(ctx context.Context, theirChannelKey ed25519.PublicKey) (*FeeSchedule, error) {
+	var res FeeSchedule
+	err := s.doQuery(ctx, theirChannelKey, GetFeeSchedule{}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	return &res, nil
+}
+
 func (s *Server) ProposeAction(ctx context.Context, channelAddr *address.Address, theirChannelKey []byte, state *cell.Cell, action Action) (*ProposalDecision, error) {
 	var res ProposalDecision
 	err := s.doQuery(ctx, theirChannelKey, ProposeAction{
-		ChannelAddr: channelAddr.Data(),
-		Action:      action,
-		SignedState: state,
+		ChannelWorkchain: channelAddr.Workchain(),
+		ChannelAddr:      channelAddr.Data(),
+		Action:           action,
+		SignedState:      state,
 	}, &res)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
+	if !res.Agreed {
+		return nil, res.rejection()
+	}
 	return &res, nil
 }
 
+// GetSharedChannels - returns channels between us and party, from the party's point of view
+func (s *Server) GetSharedChannels(ctx context.Context, theirChannelKey ed25519.PublicKey) ([]SharedChannel, error) {
+	var res SharedChannels
+	err := s.doQuery(ctx, theirChannelKey, GetSharedChannels{}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	return res.List, nil
+}
+
+// GetChannelState - returns party's latest signed state of the channel, signature is verified using their channel key
+func (s *Server) GetChannelState(ctx context.Context, channelAddr *address.Address, theirChannelKey ed25519.PublicKey) (*payments.SignedSemiChannel, error) {
+	var res ChannelState
+	err := s.doQuery(ctx, theirChannelKey, GetChannelState{
+		ChannelWorkchain: channelAddr.Workchain(),
+		ChannelAddr:      channelAddr.Data(),
+	}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	if res.SignedState == nil {
+		return nil, fmt.Errorf("state is empty")
+	}
+
+	var state payments.SignedSemiChannel
+	if err = tlb.LoadFromCell(&state, res.SignedState.BeginParse()); err != nil {
+		return nil, fmt.Errorf("failed to parse channel state: %w", err)
+	}
+
+	if err = state.Verify(theirChannelKey); err != nil {
+		return nil, fmt.Errorf("failed to verify channel state: %w", err)
+	}
+	return &state, nil
+}
+
+// NegotiateSettlement - proposes party settlement policy of our channels, returns error when party is not agreed.
+// Interval is rounded down to seconds, zero interval or threshold means it is not used.
+func (s *Server) NegotiateSettlement(ctx context.Context, interval time.Duration, threshold *big.Int, theirChannelKey []byte) (*Decision, error) {
+	var res Decision
+	err := s.doQuery(ctx, theirChannelKey, NegotiateSettlement{
+		Interval:  int64(interval / time.Second),
+		Threshold: threshold.Bytes(),
+	}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	if !res.Agreed {
+		return nil, res.rejection()
+	}
+	return &res, nil
+}
+
+// ConfirmClose - notifies party about submitted settlement transaction of cooperatively closed channel,
+// returns error when party has not accepted the confirmation
+func (s *Server) ConfirmClose(ctx context.Context, channelAddr *address.Address, txHash []byte, theirChannelKey []byte) (*Decision, error) {
+	if len(txHash) != 32 {
+		return nil, fmt.Errorf("incorrect tx hash size")
+	}
+
+	var res Decision
+	err := s.doQuery(ctx, theirChannelKey, ConfirmClose{
+		ChannelWorkchain: channelAddr.Workchain(),
+		ChannelAddr:      channelAddr.Data(),
+		TxHash:           txHash,
+	}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	if !res.Agreed {
+		return nil, res.rejection()
+	}
+	return &res, nil
+}
+
+// ProposeActions - proposes batch of actions in one round-trip, party applies all of them or none
+func (s *Server) ProposeActions(ctx context.Context, theirChannelKey []byte, actions []ProposeAction) (*ProposalDecisions, error) {
+	var res ProposalDecisions
+	err := s.doQuery(ctx, theirChannelKey, ProposeActions{
+		Actions: actions,
+	}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	if len(res.List) != len(actions) {
+		return nil, fmt.Errorf("incorrect decisions number %d, want %d", len(res.List), len(actions))
+	}
+	for _, d := range res.List {
+		// batch is applied all-or-nothing, so any rejection means all are rejected
+		if !d.Agreed {
+			return nil, d.rejection()
+		}
+	}
+	return &res, nil
+}
+
+// RequestAction - asks party to process action, when agreed, decision can contain hash of party's resulting channel state
 func (s *Server) RequestAction(ctx context.Context, channelAddr *address.Address, theirChannelKey []byte, action Action) (*Decision, error) {
 	var res Decision
 	err := s.doQuery(ctx, theirChannelKey, RequestAction{
-		ChannelAddr: channelAddr.Data(),
-		Action:      action,
+		ChannelWorkchain: channelAddr.Workchain(),
+		ChannelAddr:      channelAddr.Data(),
+		Action:           action,
 	}, &res)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
+	if !res.Agreed {
+		return nil, res.rejection()
+	}
 	return &res, nil
 }
 
-func (s *Server) RequestInboundChannel(ctx context.Context, capacity *big.Int, ourWallet *address.Address, ourKey, theirKey []byte) (*Decision, error) {
+// RequestInboundChannel - asks party to deploy channel with us, jettonMaster can be nil for native TON channel.
+// When party has no free liquidity now, InsufficientLiquidityError is returned, and request can be repeated later.
+func (s *Server) RequestInboundChannel(ctx context.Context, capacity *big.Int, jettonMaster, ourWallet *address.Address, ourKey, theirKey []byte) (*Decision, error) {
+	req := RequestInboundChannel{
+		Key:             ourKey,
+		WalletWorkchain: ourWallet.Workchain(),
+		Wallet:          ourWallet.Data(),
+		Capacity:        capacity.Bytes(),
+	}
+	if jettonMaster != nil {
+		req.Flags |= 1
+		req.JettonMaster = jettonMaster.Data()
+	}
+
 	var res Decision
-	err := s.doQuery(ctx, theirKey, RequestInboundChannel{
-		Key:      ourKey,
-		Wallet:   ourWallet.Data(),
-		Capacity: capacity.Bytes(),
-	}, &res)
+	err := s.doQuery(ctx, theirKey, req, &res)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
+	if !res.Agreed {
+		return nil, inboundDecisionError(&res)
+	}
 	return &res, nil
 }
 
 func (s *Server) doQuery(ctx context.Context, theirKey []byte, req, resp tl.Serializable) error {
+	if err := s.checkColdDeadline(ctx, theirKey); err != nil {
+		s.observeQuery(queryKind(req), 0, err)
+		return err
+	}
+
+	s.mx.RLock()
+	retries := s.queryRetries
+	s.mx.RUnlock()
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		retry := attempt < retries
+		failed, err := s.doQueryAttempt(ctx, theirKey, req, resp, retry)
+		if !failed && err != nil && lastErr != nil {
+			// reconnect is failed, original query error is more relevant for the caller
+			log.Debug().Err(err).Str("source", "server").Str("query", queryKind(req)).Msg("failed to reconnect to retry query")
+			return lastErr
+		}
+		if err == nil || !failed || !retry || ctx.Err() != nil {
+			return err
+		}
+		lastErr = err
+		log.Debug().Err(err).Str("source", "server").Str("query", queryKind(req)).
+			Int("attempt", attempt+1).Msg("query failed, reconnecting to retry")
+	}
+}
+
+// doQueryAttempt - makes query once, returns true when peer was prepared but query itself is failed.
+// When reconnect is true, failed peer connection is dropped, so the next attempt will connect and authenticate again.
+func (s *Server) doQueryAttempt(ctx context.Context, theirKey []byte, req, resp tl.Serializable, reconnect bool) (bool, error) {
+	prepareStart := time.Now()
 	peer, err := s.preparePeer(ctx, theirKey)
 	if err != nil {
-		return fmt.Errorf("failed to prepare peer: %w", err)
+		err = fmt.Errorf("failed to prepare peer: %w", err)
+		s.observeQuery(queryKind(req), time.Since(prepareStart), err)
+		return false, err
 	}
 
-	var cancel func()
-	dl, ok := ctx.Deadline()
-	if !ok || dl.After(time.Now().Add(7*time.Second)) {
-		ctx, cancel = context.WithTimeout(ctx, 7*time.Second)
+	timeouts := s.getTimeouts()
+	if _, ok := ctx.Deadline(); !ok {
+		// caller's deadline is respected even when it is longer than default
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, s.queryTimeout(theirKey))
 		defer cancel()
 	}
 
+	peer.touch()
+
+	s.tracePayload(nil, theirKey, "outbound query", req)
+
 	tm := time.Now()
-	err = peer.rldp.DoQuery(ctx, _RLDPMaxAnswerSize, req, resp)
+	atomic.AddInt32(&peer.activeTransfers, 1)
+	err = doTypedQuery(ctx, peer.rldp, s.maxAnswerSize(req), req, resp)
+	atomic.AddInt32(&peer.activeTransfers, -1)
+	s.observeQuery(queryKind(req), time.Since(tm), err)
+	if err == nil {
+		peer.markSuccess()
+		s.tracePayload(nil, theirKey, "outbound answer", resp)
+	}
+	if errors.Is(err, ErrUnexpectedResponse) || errors.Is(err, ErrAnswerTooLarge) {
+		// peer is reachable, retry will not help
+		return false, err
+	}
 	if err != nil {
 		// TODO: check other network cases too
-		if time.Since(tm) > 3*time.Second {
-			// drop peer to reconnect
+		if time.Since(tm) > timeouts.DropPeerAfter {
+			// drop peer to reconnect, its address could be changed, so resolve it again
+			s.dhtCache.invalidate(theirKey)
+			peer.adnl.Close()
+		} else if reconnect {
+			peer.adnl.Close()
+		}
+
+		if s.isResumed(peer) {
+			// party could lose our session, so authenticate again on the next connection
+			s.dropSession(theirKey)
 			peer.adnl.Close()
 		}
-		return fmt.Errorf("failed to make request: %w", err)
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			return true, fmt.Errorf("%w: %w", ErrQueryTimeout, err)
+		}
+		return true, fmt.Errorf("failed to make request: %w", err)
+	}
+	return false, nil
+}
+
+// doTypedQuery - makes rldp query and checks that answer has the same type as resp points to,
+// rldp sets answer using reflection and panics on type mismatch, so we receive it as any first.
+func doTypedQuery(ctx context.Context, rl *rldp.RLDP, maxAnswerSize int64, req, resp tl.Serializable) error {
+	var raw tl.Serializable
+	if err := rl.DoQuery(ctx, maxAnswerSize, wireQuery(req), &raw); err != nil {
+		return err
+	}
+
+	dst := reflect.ValueOf(resp).Elem()
+	if d, ok := raw.(Decision); ok && dst.Type() != reflect.TypeOf(d) && !d.Agreed && d.Reason == ErrAnswerTooLarge.Error() {
+		// party has no room for the answer, and rejected query instead
+		return fmt.Errorf("%w: more than %d bytes, expected %s", ErrAnswerTooLarge, maxAnswerSize, dst.Type().String())
+	}
+	if raw == nil || reflect.TypeOf(raw) != dst.Type() {
+		return fmt.Errorf("%w: received %T, expected %s", ErrUnexpectedResponse, raw, dst.Type().String())
 	}
+	dst.Set(reflect.ValueOf(raw))
 	return nil
 }
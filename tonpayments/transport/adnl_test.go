@@ -0,0 +1,1489 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/xssnick/ton-payment-network/pkg/payments"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/rldp"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testService struct {
+	cfg  ChannelConfig
+	fees FeeSchedule
+
+	sharedChannels       func(ctx context.Context, key ed25519.PublicKey) ([]SharedChannel, error)
+	channelState         func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*payments.SignedSemiChannel, error)
+	processAction        func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, signedState payments.SignedSemiChannel, action Action) (*payments.SignedSemiChannel, error)
+	processActions       func(ctx context.Context, key ed25519.PublicKey, proposals []ActionProposal) ([]*payments.SignedSemiChannel, error)
+	processActionRequest func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, action Action) ([]byte, error)
+	negotiateSettlement  func(ctx context.Context, key ed25519.PublicKey, interval time.Duration, threshold *big.Int) error
+	confirmClose         func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, txHash []byte) error
+	balanceAttestation   func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*big.Int, error)
+	processInbound       func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error
+}
+
+func (t *testService) GetChannelConfig() ChannelConfig {
+	return t.cfg
+}
+
+func (t *testService) GetFeeSchedule() FeeSchedule {
+	return t.fees
+}
+
+func (t *testService) GetSharedChannels(ctx context.Context, key ed25519.PublicKey) ([]SharedChannel, error) {
+	if t.sharedChannels == nil {
+		return nil, fmt.Errorf("not implemented")
+	}
+	return t.sharedChannels(ctx, key)
+}
+
+func (t *testService) GetChannelState(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*payments.SignedSemiChannel, error) {
+	if t.channelState == nil {
+		return nil, fmt.Errorf("not implemented")
+	}
+	return t.channelState(ctx, key, channelAddr)
+}
+
+func (t *testService) ProcessAction(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, signedState payments.SignedSemiChannel, action Action) (*payments.SignedSemiChannel, error) {
+	if t.processAction == nil {
+		return nil, fmt.Errorf("not implemented")
+	}
+	return t.processAction(ctx, key, channelAddr, signedState, action)
+}
+
+func (t *testService) ProcessActions(ctx context.Context, key ed25519.PublicKey, proposals []ActionProposal) ([]*payments.SignedSemiChannel, error) {
+	if t.processActions == nil {
+		return nil, fmt.Errorf("not implemented")
+	}
+	return t.processActions(ctx, key, proposals)
+}
+
+func (t *testService) ProcessActionRequest(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, action Action) ([]byte, error) {
+	if t.processActionRequest == nil {
+		return nil, fmt.Errorf("not implemented")
+	}
+	return t.processActionRequest(ctx, key, channelAddr, action)
+}
+
+func (t *testService) NegotiateSettlement(ctx context.Context, key ed25519.PublicKey, interval time.Duration, threshold *big.Int) error {
+	if t.negotiateSettlement == nil {
+		return fmt.Errorf("not implemented")
+	}
+	return t.negotiateSettlement(ctx, key, interval, threshold)
+}
+
+func (t *testService) ProcessCloseConfirmation(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, txHash []byte) error {
+	if t.confirmClose == nil {
+		return fmt.Errorf("not implemented")
+	}
+	return t.confirmClose(ctx, key, channelAddr, txHash)
+}
+
+func (t *testService) GetBalanceAttestation(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*big.Int, error) {
+	if t.balanceAttestation == nil {
+		return nil, fmt.Errorf("not implemented")
+	}
+	return t.balanceAttestation(ctx, key, channelAddr)
+}
+
+func (t *testService) ProcessInboundChannelRequest(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+	if t.processInbound == nil {
+		return fmt.Errorf("not implemented")
+	}
+	return t.processInbound(ctx, capacity, jettonMaster, walletAddr, key)
+}
+
+// newTestServer - starts server on a local udp port, without dht,
+// setup is called before gateway is started
+func newTestServer(t *testing.T, svc Service, setup ...func(s *Server)) *Server {
+	_, key, _ := ed25519.GenerateKey(nil)
+	_, channelKey, _ := ed25519.GenerateKey(nil)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	// handlers are set before gateway is started, so it never sees them half-initialized
+	gate := adnl.NewGateway(key)
+	s := NewServer(nil, gate, key, channelKey, false, DHTBackoff{})
+	s.SetService(svc)
+	for _, f := range setup {
+		f(s)
+	}
+
+	if err = gate.StartServer(addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = gate.Close()
+	})
+	return s
+}
+
+func testAddr(s *Server) string {
+	a := s.gate.GetAddressList().Addresses[0]
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(int(a.Port)))
+}
+
+// connectTestServers - connects and authenticates from with to, bypassing dht resolve
+func connectTestServers(t *testing.T, from, to *Server) *PeerConnection {
+	client, err := from.gate.RegisterClient(testAddr(to), to.key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := from.bootstrapPeer(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = from.auth(ctx, peer); err != nil {
+		t.Fatal(err)
+	}
+	return peer
+}
+
+func TestServer_GetChannelConfig(t *testing.T) {
+	svc := &testService{
+		cfg: ChannelConfig{
+			ExcessFee:   big.NewInt(1000).Bytes(),
+			WalletAddr:  make([]byte, 32),
+			MinCapacity: big.NewInt(100).Bytes(),
+			MaxCapacity: big.NewInt(15000).Bytes(),
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cfg, err := a.GetChannelConfig(ctx, b.channelKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if new(big.Int).SetBytes(cfg.MinCapacity).Int64() != 100 {
+		t.Fatal("incorrect min capacity")
+	}
+	if new(big.Int).SetBytes(cfg.MaxCapacity).Int64() != 15000 {
+		t.Fatal("incorrect max capacity")
+	}
+}
+
+func TestServer_MaxConcurrentConnects(t *testing.T) {
+	s := newTestServer(t, &testService{})
+	s.SetMaxConcurrentConnects(2)
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := s.acquireConnectSlot(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := s.acquireConnectSlot(ctx); err == nil {
+		t.Fatal("slot acquired over the limit")
+	}
+
+	got := make(chan func())
+	go func() {
+		release, err := s.acquireConnectSlot(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		got <- release
+	}()
+
+	select {
+	case <-got:
+		t.Fatal("slot acquired before release")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	releases[0]()
+	select {
+	case release := <-got:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("slot was not acquired after release")
+	}
+}
+
+func TestServer_RequestInboundChannel_Workchain(t *testing.T) {
+	var gotWallet *address.Address
+	svc := &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			gotWallet = walletAddr
+			return nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wallet := address.NewAddress(0, 255, make([]byte, 32))
+	res, err := a.RequestInboundChannel(ctx, big.NewInt(1000), nil, wallet,
+		a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.Agreed {
+		t.Fatal("not agreed:", res.Reason)
+	}
+	if gotWallet.Workchain() != -1 {
+		t.Fatal("incorrect wallet workchain", gotWallet.Workchain())
+	}
+}
+
+func TestServer_RequestInboundChannel_Jetton(t *testing.T) {
+	var gotJetton atomic.Pointer[address.Address]
+	var rejectJettons atomic.Bool
+	svc := &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			if jettonMaster != nil && rejectJettons.Load() {
+				return fmt.Errorf("jetton %s is not supported", jettonMaster.String())
+			}
+			if jettonMaster != nil {
+				// copy, parsed data can be reused by rldp
+				jettonMaster = address.NewAddress(0, byte(jettonMaster.Workchain()), append([]byte{}, jettonMaster.Data()...))
+			}
+			gotJetton.Store(jettonMaster)
+			return nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wallet := address.NewAddress(0, 0, make([]byte, 32))
+	ourKey, theirKey := a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey)
+
+	// absent jetton means native TON
+	if _, err := a.RequestInboundChannel(ctx, big.NewInt(1000), nil, wallet, ourKey, theirKey); err != nil {
+		t.Fatal(err)
+	}
+	if j := gotJetton.Load(); j != nil {
+		t.Fatal("jetton should be nil for native TON", j.String())
+	}
+
+	jetton := address.NewAddress(0, 0, bytes.Repeat([]byte{0xCD}, 32))
+	if _, err := a.RequestInboundChannel(ctx, big.NewInt(1000), jetton, wallet, ourKey, theirKey); err != nil {
+		t.Fatal(err)
+	}
+	if j := gotJetton.Load(); j == nil || !bytes.Equal(j.Data(), jetton.Data()) {
+		t.Fatal("incorrect jetton master")
+	}
+
+	// unsupported asset is rejected with reason
+	rejectJettons.Store(true)
+	_, err := a.RequestInboundChannel(ctx, big.NewInt(1000), jetton, wallet, ourKey, theirKey)
+	var decErr *DecisionError
+	if !errors.As(err, &decErr) || !strings.Contains(decErr.Reason, "is not supported") {
+		t.Fatal("unsupported jetton should be rejected:", err)
+	}
+}
+
+type testAuthorizer struct {
+	allowed ed25519.PublicKey
+}
+
+func (t *testAuthorizer) Authorize(ctx context.Context, channelKey ed25519.PublicKey) error {
+	if !channelKey.Equal(t.allowed) {
+		return fmt.Errorf("no funded channel")
+	}
+	return nil
+}
+
+func TestServer_Authorizer(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{}, func(s *Server) {
+		s.SetAuthorizer(&testAuthorizer{})
+	})
+
+	client, err := a.gate.RegisterClient(testAddr(b), b.key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := a.bootstrapPeer(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	if err = a.auth(ctx, peer); err == nil {
+		t.Fatal("unauthorized peer was accepted")
+	}
+
+	b.mx.RLock()
+	n := len(b.peersByKey)
+	b.mx.RUnlock()
+	if n != 0 {
+		t.Fatal("unauthorized peer was stored")
+	}
+
+	// now the opposite, we reject their valid answer
+	a = newTestServer(t, &testService{}, func(s *Server) {
+		s.SetAuthorizer(&testAuthorizer{allowed: s.channelKey.Public().(ed25519.PublicKey)})
+	})
+	b = newTestServer(t, &testService{})
+
+	client, err = a.gate.RegisterClient(testAddr(b), b.key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer = a.bootstrapPeer(client)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err = a.auth(ctx, peer); err == nil {
+		t.Fatal("unauthorized peer was accepted")
+	}
+	a.mx.RLock()
+	authKey := peer.authKey
+	a.mx.RUnlock()
+	if authKey != nil {
+		t.Fatal("unauthorized peer was stored")
+	}
+}
+
+func TestServer_GetFeeSchedule(t *testing.T) {
+	validUntil := time.Now().Add(5 * time.Minute).Unix()
+	svc := &testService{
+		fees: FeeSchedule{
+			ExcessFee:         big.NewInt(10).Bytes(),
+			VirtualChannelFee: big.NewInt(20).Bytes(),
+			ValidUntil:        validUntil,
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fees, err := a.GetFeeSchedule(ctx, b.channelKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fees.ValidUntil != validUntil {
+		t.Fatal("incorrect validity window", fees.ValidUntil)
+	}
+	if new(big.Int).SetBytes(fees.VirtualChannelFee).Int64() != 20 {
+		t.Fatal("incorrect virtual channel fee")
+	}
+}
+
+func TestServer_ActiveTransfers(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	svc := &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			close(started)
+			<-release
+			return nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	peerA := connectTestServers(t, a, b)
+
+	b.mx.RLock()
+	peerB := b.peersByKey[string(a.channelKey.Public().(ed25519.PublicKey))]
+	b.mx.RUnlock()
+	waitNoTransfers(t, peerB)
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := a.RequestInboundChannel(ctx, big.NewInt(1000), nil, address.NewAddress(0, 0, make([]byte, 32)),
+			a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+		done <- err
+	}()
+
+	<-started
+	if n := peerA.ActiveTransfers(); n != 1 {
+		t.Fatal("incorrect outbound active transfers", n)
+	}
+	if n := peerB.ActiveTransfers(); n != 1 {
+		t.Fatal("incorrect inbound active transfers", n)
+	}
+	for _, p := range a.ListPeers() {
+		if n := p.ActiveTransfers; n != 1 {
+			t.Fatal("incorrect active transfers in peers list", n)
+		}
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := peerA.ActiveTransfers(); n != 0 {
+		t.Fatal("outbound transfer was not released", n)
+	}
+
+	waitNoTransfers(t, peerB)
+}
+
+// waitNoTransfers - waits for answers to be delivered, sender side completes transfer after confirmation
+func waitNoTransfers(t *testing.T, peer *PeerConnection) {
+	for i := 0; peer.ActiveTransfers() != 0; i++ {
+		if i > 100 {
+			t.Fatal("transfers were not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_InternalErrors(t *testing.T) {
+	if _, err := authDigest(make([]byte, 5), make([]byte, 32), 1, AuthAlgorithmEd25519); !errors.Is(err, ErrInternal) {
+		t.Fatal("hash failure is not classified as internal:", err)
+	}
+
+	s := newTestServer(t, &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			panic("serialization bug")
+		},
+	})
+
+	err := s.processQuery(&PeerConnection{}, nil, &rldp.Query{
+		Data: RequestInboundChannel{
+			Key:      make([]byte, 32),
+			Wallet:   make([]byte, 32),
+			Capacity: big.NewInt(1).Bytes(),
+		},
+	}, time.Now())
+	if !errors.Is(err, ErrInternal) {
+		t.Fatal("panic is not recovered as internal error:", err)
+	}
+}
+
+func TestServer_Timeouts(t *testing.T) {
+	var handleBudget int64
+	svc := &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			dl, _ := ctx.Deadline()
+			atomic.StoreInt64(&handleBudget, int64(time.Until(dl)))
+			time.Sleep(500 * time.Millisecond)
+			return nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	a.SetTimeouts(Timeouts{QueryTimeout: 200 * time.Millisecond, DropPeerAfter: time.Minute})
+	// peer cannot be reconnected without dht
+	a.SetQueryRetries(0)
+	b.SetTimeouts(Timeouts{HandleTimeout: 30 * time.Second})
+	connectTestServers(t, a, b)
+
+	request := func(ctx context.Context) error {
+		_, err := a.RequestInboundChannel(ctx, big.NewInt(1000), nil, address.NewAddress(0, 0, make([]byte, 32)),
+			a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+		return err
+	}
+
+	if err := request(context.Background()); !errors.Is(err, ErrQueryTimeout) {
+		t.Fatal("default query timeout is not applied:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// longer deadline of the caller should not be shortened
+	if err := request(ctx); err != nil {
+		t.Fatal("longer caller's deadline is not respected:", err)
+	}
+
+	if budget := time.Duration(atomic.LoadInt64(&handleBudget)); budget < 29*time.Second {
+		t.Fatal("handle timeout is not applied", budget)
+	}
+}
+
+func testSignedState(seqno uint64) payments.SignedSemiChannel {
+	return payments.SignedSemiChannel{
+		Signature: payments.Signature{Value: make([]byte, 64)},
+		State: payments.SemiChannel{
+			ChannelID: make([]byte, 16),
+			Data: payments.SemiChannelBody{
+				Seqno:        seqno,
+				Sent:         tlb.ZeroCoins,
+				Conditionals: cell.NewDict(32),
+			},
+		},
+	}
+}
+
+func TestServer_ProposeActions(t *testing.T) {
+	var rejectAt atomic.Int32
+	rejectAt.Store(-1)
+
+	svc := &testService{
+		processActions: func(ctx context.Context, key ed25519.PublicKey, proposals []ActionProposal) ([]*payments.SignedSemiChannel, error) {
+			var res []*payments.SignedSemiChannel
+			for i, p := range proposals {
+				if int32(i) == rejectAt.Load() {
+					return nil, fmt.Errorf("action %d: rejected", i)
+				}
+				if _, ok := p.Action.(IncrementStatesAction); !ok {
+					return nil, fmt.Errorf("unexpected action type %T", p.Action)
+				}
+				st := testSignedState(p.SignedState.State.Data.Seqno)
+				res = append(res, &st)
+			}
+			return res, nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	batch := func(seqno uint64) []ProposeAction {
+		var actions []ProposeAction
+		for i := 0; i < 3; i++ {
+			st, err := tlb.ToCell(testSignedState(seqno + uint64(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := make([]byte, 32)
+			addr[0] = byte(i)
+
+			actions = append(actions, ProposeAction{
+				ChannelAddr: addr,
+				Action:      IncrementStatesAction{},
+				SignedState: st,
+			})
+		}
+		return actions
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := a.ProposeActions(ctx, b.channelKey.Public().(ed25519.PublicKey), batch(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range res.List {
+		if !d.Agreed {
+			t.Fatal("action not agreed:", i, d.Reason)
+		}
+
+		var st payments.SignedSemiChannel
+		if err = tlb.LoadFromCell(&st, d.SignedState.BeginParse()); err != nil {
+			t.Fatal(err)
+		}
+		if st.State.Data.Seqno != uint64(i+1) {
+			t.Fatal("incorrect order of decisions")
+		}
+	}
+
+	// replayed batch is passed to service, which answers it idempotently
+	if _, err = a.ProposeActions(ctx, b.channelKey.Public().(ed25519.PublicKey), batch(1)); err != nil {
+		t.Fatal("replayed batch should be accepted:", err)
+	}
+
+	// rolled back batch is rejected before service
+	_, err = a.ProposeActions(ctx, b.channelKey.Public().(ed25519.PublicKey), batch(0))
+
+	var decErr *DecisionError
+	if !errors.As(err, &decErr) || !strings.Contains(decErr.Reason, "is older") {
+		t.Fatal("rolled back batch should be rejected:", err)
+	}
+
+	// one rejected action rejects the whole batch
+	rejectAt.Store(1)
+	_, err = a.ProposeActions(ctx, b.channelKey.Public().(ed25519.PublicKey), batch(4))
+	if !errors.As(err, &decErr) {
+		t.Fatal("batch should be rejected:", err)
+	}
+	if decErr.Reason != "action 1: rejected" {
+		t.Fatal("incorrect reason:", decErr.Reason)
+	}
+}
+
+func TestServer_ConnectAddresses(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	a.SetTimeouts(Timeouts{ConnectAddressTimeout: 300 * time.Millisecond})
+
+	// nobody listens on this port
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peer, err := a.connectAddresses(ctx, b.key.Public().(ed25519.PublicKey), []string{"bad address", dead, testAddr(b)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(peer.authKey, b.channelKey.Public().(ed25519.PublicKey)) {
+		t.Fatal("incorrect auth key")
+	}
+
+	c := newTestServer(t, &testService{})
+	c.SetTimeouts(Timeouts{ConnectAddressTimeout: 300 * time.Millisecond})
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = c.connectAddresses(ctx, b.key.Public().(ed25519.PublicKey), []string{"bad address", dead})
+	if err == nil {
+		t.Fatal("should fail")
+	}
+	if !strings.Contains(err.Error(), "bad address") || !strings.Contains(err.Error(), dead) {
+		t.Fatal("not all addresses are reported:", err)
+	}
+}
+
+func TestFitAnswer(t *testing.T) {
+	st, err := tlb.ToCell(testSignedState(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := ProposalDecisions{}
+	for i := 0; i < 100; i++ {
+		res.List = append(res.List, ProposalDecision{Agreed: true, SignedState: st})
+	}
+	tooLarge := ProposalDecisions{List: []ProposalDecision{{Reason: ErrAnswerTooLarge.Error()}}}
+
+	query := &rldp.Query{ID: make([]byte, 32), MaxAnswerSize: _RLDPMaxAnswerSize}
+	answer, err := fitAnswer(query, res, tooLarge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answer.(ProposalDecisions).List) != 100 {
+		t.Fatal("answer should fit")
+	}
+
+	query.MaxAnswerSize = 1024
+	answer, err = fitAnswer(query, res, tooLarge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.(ProposalDecisions).List[0].Reason != ErrAnswerTooLarge.Error() {
+		t.Fatal("too large answer was not replaced")
+	}
+}
+
+func TestServer_DecisionError(t *testing.T) {
+	svc := &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			return fmt.Errorf("not enough capacity")
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := a.RequestInboundChannel(ctx, big.NewInt(1000), nil, address.NewAddress(0, 0, make([]byte, 32)),
+		a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+
+	var decErr *DecisionError
+	if !errors.As(err, &decErr) {
+		t.Fatal("not a decision error:", err)
+	}
+	if decErr.Reason != "not enough capacity" {
+		t.Fatal("incorrect reason:", decErr.Reason)
+	}
+	if errors.Is(err, ErrQueryTimeout) || errors.Is(err, ErrPeerUnreachable) {
+		t.Fatal("rejection reported as network failure")
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	started := make(chan struct{})
+	var aborted atomic.Bool
+	svc := &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			close(started)
+			<-ctx.Done()
+			aborted.Store(true)
+			return ctx.Err()
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	b.SetTimeouts(Timeouts{HandleTimeout: time.Minute})
+	connectTestServers(t, a, b)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, _ = a.RequestInboundChannel(ctx, big.NewInt(1000), nil, address.NewAddress(0, 0, make([]byte, 32)),
+			a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler is not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := b.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !aborted.Load() {
+		t.Fatal("handler was not cancelled")
+	}
+
+	b.mx.RLock()
+	peers := len(b.peers)
+	b.mx.RUnlock()
+	if peers != 0 {
+		t.Fatal("peers are not closed", peers)
+	}
+
+	if err := b.handleRLDPQuery(&PeerConnection{})(nil, &rldp.Query{}); err == nil {
+		t.Fatal("query accepted after close")
+	}
+
+	// repeated close is no-op
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_ProposeAction_SeqnoRollback(t *testing.T) {
+	var calls atomic.Int32
+	svc := &testService{
+		processAction: func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, signedState payments.SignedSemiChannel, action Action) (*payments.SignedSemiChannel, error) {
+			calls.Add(1)
+			st := testSignedState(signedState.State.Data.Seqno)
+			return &st, nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	propose := func(seqno uint64) error {
+		st, err := tlb.ToCell(testSignedState(seqno))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = a.ProposeAction(ctx, address.NewAddress(0, 0, make([]byte, 32)),
+			b.channelKey.Public().(ed25519.PublicKey), st, IncrementStatesAction{})
+		return err
+	}
+
+	if err := propose(5); err != nil {
+		t.Fatal(err)
+	}
+
+	// replay is passed to service, which answers it idempotently
+	if err := propose(5); err != nil {
+		t.Fatal("replay rejected:", err)
+	}
+
+	var decErr *DecisionError
+	if err := propose(4); !errors.As(err, &decErr) {
+		t.Fatal("rollback accepted:", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatal("rollback reached service")
+	}
+
+	if err := propose(6); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_ProposeAction_ConcurrentDuplicate(t *testing.T) {
+	var inProgress, maxInProgress, calls atomic.Int32
+	svc := &testService{
+		processAction: func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, signedState payments.SignedSemiChannel, action Action) (*payments.SignedSemiChannel, error) {
+			calls.Add(1)
+			if n := inProgress.Add(1); n > maxInProgress.Load() {
+				maxInProgress.Store(n)
+			}
+			// keep the first proposal in progress while duplicates arrive
+			time.Sleep(100 * time.Millisecond)
+			inProgress.Add(-1)
+
+			st := testSignedState(signedState.State.Data.Seqno)
+			return &st, nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 5
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		// cell per caller, cells cache hash on first use and are not safe to share
+		st, err := tlb.ToCell(testSignedState(7))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = a.ProposeAction(ctx, address.NewAddress(0, 0, make([]byte, 32)),
+				b.channelKey.Public().(ed25519.PublicKey), st, IncrementStatesAction{})
+		}(i)
+	}
+	wg.Wait()
+
+	// duplicates are answered idempotently by service, one by one
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != n || maxInProgress.Load() != 1 {
+		t.Fatal("duplicate proposals are not serialized", calls.Load(), maxInProgress.Load())
+	}
+}
+
+func TestServer_ProposeAction_ReplayAfterLostAnswer(t *testing.T) {
+	var calls atomic.Int32
+	svc := &testService{
+		processAction: func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, signedState payments.SignedSemiChannel, action Action) (*payments.SignedSemiChannel, error) {
+			if calls.Add(1) == 1 {
+				// accepted, but answered too late, so the answer is lost for the sender
+				time.Sleep(500 * time.Millisecond)
+			}
+			st := testSignedState(signedState.State.Data.Seqno)
+			return &st, nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	a.SetQueryRetries(0)
+	connectTestServers(t, a, b)
+
+	propose := func(timeout time.Duration) (*ProposalDecision, error) {
+		st, err := tlb.ToCell(testSignedState(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return a.ProposeAction(ctx, address.NewAddress(0, 0, make([]byte, 32)),
+			b.channelKey.Public().(ed25519.PublicKey), st, IncrementStatesAction{})
+	}
+
+	if _, err := propose(200 * time.Millisecond); err == nil {
+		t.Fatal("answer should be lost")
+	}
+	// let party finish and remember accepted proposal
+	time.Sleep(500 * time.Millisecond)
+
+	res, err := propose(5 * time.Second)
+	if err != nil {
+		t.Fatal("replay after lost answer is rejected:", err)
+	}
+	var signed payments.SignedSemiChannel
+	if err = tlb.LoadFromCell(&signed, res.SignedState.BeginParse()); err != nil {
+		t.Fatal(err)
+	}
+	if signed.State.Data.Seqno != 3 || calls.Load() != 2 {
+		t.Fatal("replay is not answered by service", signed.State.Data.Seqno, calls.Load())
+	}
+}
+
+func TestServer_ListPeers(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	before := time.Now()
+	peer := connectTestServers(t, a, b)
+
+	list := a.ListPeers()
+	if len(list) != 1 {
+		t.Fatal("incorrect peers number", len(list))
+	}
+
+	info := list[0]
+	if !bytes.Equal(info.AuthKey, b.channelKey.Public().(ed25519.PublicKey)) {
+		t.Fatal("incorrect auth key")
+	}
+	if !bytes.Equal(info.ADNLID, peer.adnl.GetID()) {
+		t.Fatal("incorrect adnl id")
+	}
+	if info.RemoteAddr != testAddr(b) {
+		t.Fatal("incorrect remote addr", info.RemoteAddr)
+	}
+	if info.ConnectedAt.Before(before) || info.ConnectedAt.After(time.Now()) {
+		t.Fatal("incorrect connection time", info.ConnectedAt)
+	}
+
+	// returned data must be a copy
+	info.AuthKey[0] ^= 0xFF
+	info.ADNLID[0] ^= 0xFF
+	if !bytes.Equal(peer.authKey, b.channelKey.Public().(ed25519.PublicKey)) || !bytes.Equal(a.ListPeers()[0].ADNLID, peer.adnl.GetID()) {
+		t.Fatal("internal state was mutated")
+	}
+}
+
+func TestCheckAuthTimestamp(t *testing.T) {
+	now := time.Now()
+	tm := DefaultTimeouts
+
+	for _, tt := range []struct {
+		delta time.Duration
+		ok    bool
+	}{
+		{0, true},
+		{2 * time.Second, true},
+		{5 * time.Second, true},
+		{6 * time.Second, false},
+		{-59 * time.Second, true},
+		{-61 * time.Second, false},
+	} {
+		err := checkAuthTimestamp(now.Add(tt.delta).Unix(), now, tm)
+		if (err == nil) != tt.ok {
+			t.Fatal("incorrect result for delta", tt.delta, err)
+		}
+	}
+
+	tm.AuthMaxSkew = 10 * time.Second
+	if err := checkAuthTimestamp(now.Add(8*time.Second).Unix(), now, tm); err != nil {
+		t.Fatal("configured skew is not applied", err)
+	}
+}
+
+func TestServer_GetSharedChannels(t *testing.T) {
+	var a *Server
+	svc := &testService{
+		sharedChannels: func(ctx context.Context, key ed25519.PublicKey) ([]SharedChannel, error) {
+			if !key.Equal(a.channelKey.Public()) {
+				return nil, fmt.Errorf("unexpected key")
+			}
+			return []SharedChannel{
+				{Address: bytes.Repeat([]byte{1}, 32), Status: 1, OurSeqno: 5, TheirSeqno: 7},
+				{Address: bytes.Repeat([]byte{2}, 32), Status: 2, OurSeqno: 1, TheirSeqno: 0},
+			}, nil
+		},
+	}
+
+	a = newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	list, err := a.GetSharedChannels(ctx, b.channelKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatal("incorrect channels number", len(list))
+	}
+	if !bytes.Equal(list[0].Address, bytes.Repeat([]byte{1}, 32)) || list[0].OurSeqno != 5 || list[0].TheirSeqno != 7 || list[0].Status != 1 {
+		t.Fatal("incorrect channel", list[0])
+	}
+	if !bytes.Equal(list[1].Address, bytes.Repeat([]byte{2}, 32)) || list[1].Status != 2 {
+		t.Fatal("incorrect channel", list[1])
+	}
+}
+
+func TestServer_KeyRebind(t *testing.T) {
+	b := newTestServer(t, &testService{})
+
+	type rebind struct {
+		key              ed25519.PublicKey
+		oldADNL, newADNL []byte
+	}
+	rebinds := make(chan rebind, 1)
+	b.SetOnKeyRebind(func(channelKey ed25519.PublicKey, oldADNL, newADNL []byte) {
+		rebinds <- rebind{channelKey, oldADNL, newADNL}
+	})
+
+	a1 := newTestServer(t, &testService{})
+	a2 := newTestServer(t, &testService{})
+	// same channel key on different adnl addresses
+	a2.channelKey = a1.channelKey
+	key := a1.channelKey.Public().(ed25519.PublicKey)
+
+	connectTestServers(t, a1, b)
+	connectTestServers(t, a2, b)
+
+	var r rebind
+	select {
+	case r = <-rebinds:
+	case <-time.After(3 * time.Second):
+		t.Fatal("rebind is not reported")
+	}
+
+	if !r.key.Equal(key) || !bytes.Equal(r.oldADNL, a1.gate.GetID()) || !bytes.Equal(r.newADNL, a2.gate.GetID()) {
+		t.Fatal("incorrect rebind info")
+	}
+
+	b.mx.RLock()
+	bound := b.peersByKey[string(key)]
+	peers := len(b.peers)
+	b.mx.RUnlock()
+
+	if !bytes.Equal(bound.adnl.GetID(), a2.gate.GetID()) {
+		t.Fatal("key is not bound to the new connection")
+	}
+	// old connection is closed, and its disconnect has not removed the new binding
+	if peers != 1 {
+		t.Fatal("old connection is not closed", peers)
+	}
+}
+
+func TestServer_GetChannelState(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	shared := bytes.Repeat([]byte{1}, 32)
+
+	svc := &testService{}
+	b := newTestServer(t, svc)
+	svc.channelState = func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*payments.SignedSemiChannel, error) {
+		if !key.Equal(a.channelKey.Public()) || !bytes.Equal(channelAddr.Data(), shared) {
+			return nil, fmt.Errorf("channel is not shared with the party")
+		}
+
+		state := testSignedState(9)
+		stateCell, err := tlb.ToCell(state.State)
+		if err != nil {
+			return nil, err
+		}
+		state.Signature.Value = stateCell.Sign(b.channelKey)
+		return &state, nil
+	}
+	a.SetQueryRetries(0)
+	a.SetTimeouts(Timeouts{QueryTimeout: 300 * time.Millisecond})
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	state, err := a.GetChannelState(ctx, address.NewAddress(0, 0, shared), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.State.Data.Seqno != 9 {
+		t.Fatal("incorrect seqno", state.State.Data.Seqno)
+	}
+
+	// not shared channel, peer should not answer
+	if _, err = a.GetChannelState(context.Background(), address.NewAddress(0, 0, make([]byte, 32)), key); err == nil {
+		t.Fatal("state of not shared channel returned")
+	}
+}
+
+func TestServer_UpdateDHTNoAddresses(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+	_, channelKey, _ := ed25519.GenerateKey(nil)
+
+	// gateway is not started, so it has no addresses, and dht must not be touched
+	s := NewServer(nil, adnl.NewGateway(key), key, channelKey, false, DHTBackoff{})
+	defer s.Close()
+
+	if err := s.updateDHT(context.Background()); !errors.Is(err, errNoAddresses) {
+		t.Fatal("store should be skipped:", err)
+	}
+}
+
+func TestServer_ChannelWorkchain(t *testing.T) {
+	workchains := make(chan int32, 1)
+	svc := &testService{
+		processAction: func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, signedState payments.SignedSemiChannel, action Action) (*payments.SignedSemiChannel, error) {
+			workchains <- channelAddr.Workchain()
+			return &signedState, nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	st, err := tlb.ToCell(testSignedState(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if _, err = a.ProposeAction(ctx, address.NewAddress(0, 255, make([]byte, 32)), key, st, IncrementStatesAction{}); err != nil {
+		t.Fatal(err)
+	}
+	if wc := <-workchains; wc != -1 {
+		t.Fatal("incorrect workchain", wc)
+	}
+
+	_, err = a.ProposeAction(ctx, address.NewAddress(0, 7, make([]byte, 32)), key, st, IncrementStatesAction{})
+
+	var decErr *DecisionError
+	if !errors.As(err, &decErr) || !strings.Contains(decErr.Reason, "workchain") {
+		t.Fatal("unsupported workchain should be rejected:", err)
+	}
+}
+
+func TestServer_UnexpectedResponse(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{}, func(s *Server) {
+		// party with different protocol version answers with decision instead of config,
+		// handler is replaced in the connection handler, before the first query is processed
+		s.gate.SetConnectionHandler(func(client adnl.Peer) error {
+			if err := s.bootstrapPeerWrap(client); err != nil {
+				return err
+			}
+
+			s.mx.RLock()
+			inbound := s.peers[string(client.GetID())]
+			s.mx.RUnlock()
+			if inbound == nil {
+				return nil
+			}
+
+			handle := s.handleRLDPQuery(inbound)
+			inbound.rldp.SetOnQuery(func(transfer []byte, query *rldp.Query) error {
+				if _, ok := query.Data.(Authenticate); ok {
+					return handle(transfer, query)
+				}
+				return inbound.rldp.SendAnswer(context.Background(), query.MaxAnswerSize, query.ID, transfer, Decision{Agreed: false, Reason: "unknown"})
+			})
+			return nil
+		})
+	})
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := a.GetChannelConfig(ctx, b.channelKey.Public().(ed25519.PublicKey))
+	if !errors.Is(err, ErrUnexpectedResponse) {
+		t.Fatal("type mismatch is not detected:", err)
+	}
+	if !strings.Contains(err.Error(), "transport.Decision") || !strings.Contains(err.Error(), "transport.ChannelConfig") {
+		t.Fatal("types are not named:", err)
+	}
+}
+
+func TestServer_OnPeerAuthenticated(t *testing.T) {
+	type event struct {
+		key     ed25519.PublicKey
+		inbound bool
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	aEvents, bEvents := make(chan event, 1), make(chan event, 1)
+	a.SetOnPeerAuthenticated(func(key ed25519.PublicKey, inbound bool) {
+		aEvents <- event{key, inbound}
+	})
+	b.SetOnPeerAuthenticated(func(key ed25519.PublicKey, inbound bool) {
+		bEvents <- event{key, inbound}
+	})
+
+	connectTestServers(t, a, b)
+
+	for _, c := range []struct {
+		events  chan event
+		key     ed25519.PublicKey
+		inbound bool
+	}{
+		{aEvents, b.channelKey.Public().(ed25519.PublicKey), false},
+		{bEvents, a.channelKey.Public().(ed25519.PublicKey), true},
+	} {
+		select {
+		case e := <-c.events:
+			if !e.key.Equal(c.key) || e.inbound != c.inbound {
+				t.Fatal("incorrect event", e.inbound)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("hook is not called")
+		}
+	}
+}
+
+func TestServer_NegotiateSettlement(t *testing.T) {
+	a := newTestServer(t, &testService{})
+
+	type policy struct {
+		interval  time.Duration
+		threshold *big.Int
+	}
+	agreed := map[string]policy{}
+
+	svc := &testService{}
+	svc.negotiateSettlement = func(ctx context.Context, key ed25519.PublicKey, interval time.Duration, threshold *big.Int) error {
+		if interval != 0 && interval < time.Hour {
+			return fmt.Errorf("settlement interval is too short")
+		}
+		agreed[string(key)] = policy{interval: interval, threshold: threshold}
+		return nil
+	}
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if _, err := a.NegotiateSettlement(ctx, 24*time.Hour, big.NewInt(5e9), key); err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok := agreed[string(a.channelKey.Public().(ed25519.PublicKey))]
+	if !ok {
+		t.Fatal("policy is not recorded")
+	}
+	if p.interval != 24*time.Hour || p.threshold.Cmp(big.NewInt(5e9)) != 0 {
+		t.Fatal("incorrect policy", p.interval, p.threshold)
+	}
+
+	_, err := a.NegotiateSettlement(ctx, time.Minute, big.NewInt(0), key)
+	var decErr *DecisionError
+	if !errors.As(err, &decErr) || !strings.Contains(decErr.Reason, "too short") {
+		t.Fatal("policy should be rejected:", err)
+	}
+}
+
+func TestServer_Warmup(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+
+	// no address known, deadline should be respected
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := a.Warmup(ctx, key); !errors.Is(err, ErrPeerUnreachable) {
+		t.Fatal("should be unreachable:", err)
+	}
+
+	if err := a.AddStaticPeer(key, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Warmup(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	a.mx.RLock()
+	peer := a.peersByKey[string(key)]
+	a.mx.RUnlock()
+	if peer == nil {
+		t.Fatal("peer is not authenticated")
+	}
+
+	before := a.QueryStats().Outbound[queryKind(Authenticate{})]
+	if err := a.Warmup(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if after := a.QueryStats().Outbound[queryKind(Authenticate{})]; after != before {
+		t.Fatal("already authenticated peer should not be authenticated again")
+	}
+}
+
+func TestServer_AuthWithOwnKey(t *testing.T) {
+	a := newTestServer(t, &testService{})
+
+	// loopback, party signs auth with our channel key
+	b := newTestServer(t, &testService{})
+	b.channelKey = a.channelKey
+
+	client, err := b.gate.RegisterClient(testAddr(a), a.key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := b.bootstrapPeer(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	if err = b.auth(ctx, peer); err == nil {
+		t.Fatal("auth with our own key should be refused")
+	}
+
+	a.mx.RLock()
+	_, ok := a.peersByKey[string(a.channelKey.Public().(ed25519.PublicKey))]
+	a.mx.RUnlock()
+	if ok {
+		t.Fatal("peer with our own key is stored")
+	}
+}
+
+func TestServer_RequestActionBaseStateHash(t *testing.T) {
+	a := newTestServer(t, &testService{})
+
+	hash := bytes.Repeat([]byte{0xAB}, 32)
+	var returnHash atomic.Pointer[[]byte]
+	returnHash.Store(&hash)
+
+	svc := &testService{}
+	svc.processActionRequest = func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, action Action) ([]byte, error) {
+		return *returnHash.Load(), nil
+	}
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	addr := address.NewAddress(0, 0, make([]byte, 32))
+
+	res, err := a.RequestAction(ctx, addr, key, RequestRemoveVirtualAction{Key: make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Flags&1 == 0 || !bytes.Equal(res.BaseStateHash, hash) {
+		t.Fatal("incorrect state hash", res.BaseStateHash)
+	}
+
+	// old parties are not returning hash
+	returnHash.Store(new([]byte))
+	res, err = a.RequestAction(ctx, addr, key, RequestRemoveVirtualAction{Key: make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Flags&1 != 0 || res.BaseStateHash != nil {
+		t.Fatal("state hash should be empty", res.BaseStateHash)
+	}
+}
+
+func TestServer_IsAuthenticated(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if a.IsAuthenticated(key) {
+		t.Fatal("should not be authenticated before connect")
+	}
+
+	peer := connectTestServers(t, a, b)
+	if !a.IsAuthenticated(key) {
+		t.Fatal("should be authenticated")
+	}
+	if !b.IsAuthenticated(a.channelKey.Public().(ed25519.PublicKey)) {
+		t.Fatal("should be authenticated on party side")
+	}
+
+	peer.adnl.Close()
+	deadline := time.Now().Add(3 * time.Second)
+	for a.IsAuthenticated(key) {
+		if time.Now().After(deadline) {
+			t.Fatal("should not be authenticated after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_ConfirmClose(t *testing.T) {
+	a := newTestServer(t, &testService{})
+
+	channel := address.NewAddress(0, 0, bytes.Repeat([]byte{0x11}, 32))
+	txHash := bytes.Repeat([]byte{0x22}, 32)
+
+	var mx sync.Mutex
+	confirmed := map[string][]byte{}
+	svc := &testService{}
+	svc.confirmClose = func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, hash []byte) error {
+		if !bytes.Equal(channelAddr.Data(), channel.Data()) {
+			return fmt.Errorf("channel close is not agreed")
+		}
+		mx.Lock()
+		confirmed[string(key)] = append([]byte{}, hash...)
+		mx.Unlock()
+		return nil
+	}
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if _, err := a.ConfirmClose(ctx, channel, txHash, key); err != nil {
+		t.Fatal(err)
+	}
+	mx.Lock()
+	got := confirmed[string(a.channelKey.Public().(ed25519.PublicKey))]
+	mx.Unlock()
+	if !bytes.Equal(got, txHash) {
+		t.Fatal("confirmation is not recorded")
+	}
+
+	_, err := a.ConfirmClose(ctx, address.NewAddress(0, 0, make([]byte, 32)), txHash, key)
+	var decErr *DecisionError
+	if !errors.As(err, &decErr) || decErr.Reason != "channel close is not agreed" {
+		t.Fatal("confirmation should be rejected:", err)
+	}
+
+	if _, err = a.ConfirmClose(ctx, channel, txHash[:31], key); err == nil {
+		t.Fatal("incorrect hash should be rejected")
+	}
+}
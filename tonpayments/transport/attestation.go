@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tl"
+	"math/big"
+	"time"
+)
+
+// signBalanceAttestation - makes attestation of balance in the channel at the given time, signed by channel key
+func signBalanceAttestation(key ed25519.PrivateKey, channelAddr *address.Address, balance *big.Int, at time.Time) (*BalanceAttestation, error) {
+	if balance.Sign() < 0 {
+		return nil, fmt.Errorf("negative balance")
+	}
+
+	res := &BalanceAttestation{
+		ChannelWorkchain: channelAddr.Workchain(),
+		ChannelAddr:      channelAddr.Data(),
+		Balance:          balance.Bytes(),
+		Timestamp:        at.Unix(),
+	}
+
+	toSign, err := res.hash()
+	if err != nil {
+		return nil, err
+	}
+	res.Signature = ed25519.Sign(key, toSign)
+	return res, nil
+}
+
+func (a *BalanceAttestation) hash() ([]byte, error) {
+	toSign, err := tl.Hash(BalanceAttestationToSign{
+		ChannelWorkchain: a.ChannelWorkchain,
+		ChannelAddr:      a.ChannelAddr,
+		Balance:          a.Balance,
+		Timestamp:        a.Timestamp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash attestation: %w", err)
+	}
+	return toSign, nil
+}
+
+// Verify - checks that attestation is signed by the channel key
+func (a *BalanceAttestation) Verify(channelKey ed25519.PublicKey) error {
+	toSign, err := a.hash()
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(channelKey, toSign, a.Signature) {
+		return fmt.Errorf("incorrect signature")
+	}
+	return nil
+}
+
+// GetBalance - attested balance
+func (a *BalanceAttestation) GetBalance() *big.Int {
+	return new(big.Int).SetBytes(a.Balance)
+}
+
+// GetTime - time when attestation was made
+func (a *BalanceAttestation) GetTime() time.Time {
+	return time.Unix(a.Timestamp, 0)
+}
+
+// GetBalanceAttestation - requests party's signed statement of its current balance in the channel,
+// signature is verified using their channel key, so attestation can be presented to third parties.
+func (s *Server) GetBalanceAttestation(ctx context.Context, channelAddr *address.Address, theirChannelKey ed25519.PublicKey) (*BalanceAttestation, error) {
+	var res BalanceAttestation
+	err := s.doQuery(ctx, theirChannelKey, GetBalanceAttestation{
+		ChannelWorkchain: channelAddr.Workchain(),
+		ChannelAddr:      channelAddr.Data(),
+	}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	if res.ChannelWorkchain != channelAddr.Workchain() || !bytes.Equal(res.ChannelAddr, channelAddr.Data()) {
+		return nil, fmt.Errorf("attestation is for another channel")
+	}
+
+	if err = res.Verify(theirChannelKey); err != nil {
+		return nil, fmt.Errorf("failed to verify attestation: %w", err)
+	}
+	return &res, nil
+}
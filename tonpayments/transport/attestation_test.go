@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/xssnick/tonutils-go/address"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestServer_GetBalanceAttestation(t *testing.T) {
+	a := newTestServer(t, &testService{})
+
+	channel := address.NewAddress(0, 0, bytes.Repeat([]byte{0x11}, 32))
+
+	svc := &testService{}
+	svc.balanceAttestation = func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address) (*big.Int, error) {
+		if !bytes.Equal(channelAddr.Data(), channel.Data()) {
+			return nil, fmt.Errorf("channel is not shared with the party")
+		}
+		return big.NewInt(12345), nil
+	}
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	before := time.Now().Add(-time.Second)
+	att, err := a.GetBalanceAttestation(ctx, channel, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.GetBalance().Int64() != 12345 {
+		t.Fatal("incorrect balance", att.GetBalance())
+	}
+	if att.GetTime().Before(before) || att.GetTime().After(time.Now()) {
+		t.Fatal("incorrect timestamp", att.GetTime())
+	}
+
+	// attestation can be verified later, by anyone who knows party's channel key
+	if err = att.Verify(key); err != nil {
+		t.Fatal(err)
+	}
+	if err = att.Verify(a.channelKey.Public().(ed25519.PublicKey)); err == nil {
+		t.Fatal("should not be verified with another key")
+	}
+
+	tampered := *att
+	tampered.Balance = big.NewInt(99999).Bytes()
+	if err = tampered.Verify(key); err == nil {
+		t.Fatal("tampered balance should not be verified")
+	}
+
+	// errors are not answered, so query is failed by timeout
+	ctxFail, cancelFail := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancelFail()
+	if _, err = a.GetBalanceAttestation(ctxFail, address.NewAddress(0, 0, make([]byte, 32)), key); err == nil {
+		t.Fatal("not shared channel should fail")
+	}
+}
@@ -0,0 +1,39 @@
+package transport
+
+import "fmt"
+
+// Auth signature algorithms, ed25519 is the only one implemented for now,
+// others are reserved for hardware wallets and alternative curves.
+const (
+	AuthAlgorithmEd25519 int32 = 0
+)
+
+// supportedAuthAlgorithms - algorithms we can verify, in order of our preference
+var supportedAuthAlgorithms = []int32{AuthAlgorithmEd25519}
+
+// checkAuthAlgorithm - rejects algorithm ids which we do not implement
+func checkAuthAlgorithm(id int32) error {
+	for _, a := range supportedAuthAlgorithms {
+		if a == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown auth signature algorithm %d", id)
+}
+
+// selectAuthAlgorithm - chooses our most preferred algorithm supported by party,
+// empty list means that party supports only ed25519
+func selectAuthAlgorithm(theirs []int32) (int32, error) {
+	if len(theirs) == 0 {
+		theirs = []int32{AuthAlgorithmEd25519}
+	}
+
+	for _, our := range supportedAuthAlgorithms {
+		for _, their := range theirs {
+			if our == their {
+				return our, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no mutually supported auth signature algorithm, party supports %v", theirs)
+}
@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectAuthAlgorithm(t *testing.T) {
+	if a, err := selectAuthAlgorithm(nil); err != nil || a != AuthAlgorithmEd25519 {
+		t.Fatal("empty list should select ed25519", a, err)
+	}
+	if a, err := selectAuthAlgorithm([]int32{77, AuthAlgorithmEd25519}); err != nil || a != AuthAlgorithmEd25519 {
+		t.Fatal("mutual algorithm is not selected", a, err)
+	}
+	if _, err := selectAuthAlgorithm([]int32{77, 78}); err == nil {
+		t.Fatal("no mutual algorithm, should fail")
+	}
+}
+
+func TestServer_AuthUnknownAlgorithm(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	client, err := a.gate.RegisterClient(testAddr(b), b.key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := a.bootstrapPeer(client)
+
+	if _, err = a.signAuth(77, []byte("data"), 1); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatal("unknown algorithm should not be signed:", err)
+	}
+
+	ts := time.Now().Unix()
+	authData, err := authDigest(a.gate.GetID(), peer.adnl.GetID(), ts, 77)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signed correctly, but with algorithm id which party does not know
+	req := Authenticate{
+		Key:       a.channelKey.Public().(ed25519.PublicKey),
+		Timestamp: ts,
+		Algorithm: 77,
+		Supported: []int32{77},
+		Signature: ed25519.Sign(a.channelKey, authData),
+	}
+	if err = verifyAuth(&req, authData); err == nil {
+		t.Fatal("unknown algorithm verified")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	var res Authenticate
+	if err = doTypedQuery(ctx, peer.rldp, _RLDPMaxAnswerSize, req, &res); err == nil {
+		t.Fatal("auth with unknown algorithm accepted")
+	}
+
+	b.mx.RLock()
+	n := len(b.peersByKey)
+	b.mx.RUnlock()
+	if n != 0 {
+		t.Fatal("peer is authenticated")
+	}
+
+	// normal handshake negotiates ed25519
+	connectTestServers(t, a, b)
+}
@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errAuthAttempt - party has answered our handshake, but authentication is failed
+var errAuthAttempt = errors.New("authentication attempt failed")
+
+// authFailure - last auth failure with the party, new attempts are not made until retryAt
+type authFailure struct {
+	err      error
+	failures int
+	retryAt  time.Time
+}
+
+// SetAuthBackoff - when base > 0, after auth failure with the party, next attempt is made not earlier than base,
+// doubled on each consecutive failure up to max. Queries within this window fail immediately with the last reason.
+// Zero base disables it.
+func (s *Server) SetAuthBackoff(base, max time.Duration) {
+	if max < base {
+		max = base
+	}
+
+	s.mx.Lock()
+	s.authBackoffBase = base
+	s.authBackoffMax = max
+	s.authFailures = map[string]*authFailure{}
+	s.mx.Unlock()
+}
+
+// checkAuthBackoff - returns last auth error when we should not try to authenticate with the party yet
+func (s *Server) checkAuthBackoff(key []byte) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	f := s.authFailures[string(key)]
+	if f == nil || !time.Now().Before(f.retryAt) {
+		return nil
+	}
+	return fmt.Errorf("%w: backoff after failure %d, next attempt in %s: %s",
+		ErrAuthFailed, f.failures, time.Until(f.retryAt).Round(time.Millisecond), f.err.Error())
+}
+
+// authFailed - remembers auth failure and schedules the next allowed attempt
+func (s *Server) authFailed(key []byte, err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.authBackoffBase <= 0 {
+		return
+	}
+
+	f := s.authFailures[string(key)]
+	if f == nil {
+		f = &authFailure{}
+		s.authFailures[string(key)] = f
+	}
+	f.err = err
+	f.failures++
+
+	wait := s.authBackoffBase
+	for i := 1; i < f.failures && wait < s.authBackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > s.authBackoffMax {
+		wait = s.authBackoffMax
+	}
+	f.retryAt = time.Now().Add(wait)
+}
+
+// authSucceeded - resets backoff of the party
+func (s *Server) authSucceeded(key []byte) {
+	s.mx.Lock()
+	delete(s.authFailures, string(key))
+	s.mx.Unlock()
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServer_AuthBackoff(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	// party is not allowed, so every auth is failed on our side
+	a.SetAuthorizer(&testAuthorizer{})
+	a.SetAuthBackoff(time.Minute, time.Hour)
+	a.SetQueryRetries(0)
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if err := a.AddStaticPeer(key, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	handshakes := func() uint64 {
+		c := a.QueryStats().Outbound["Authenticate"]
+		return c.Success + c.Failure
+	}
+
+	if _, err := a.GetChannelConfig(ctx, key); err == nil {
+		t.Fatal("should fail")
+	}
+	if n := handshakes(); n != 1 {
+		t.Fatal("incorrect handshakes", n)
+	}
+
+	// rapid queries are failed without new attempts
+	for i := 0; i < 5; i++ {
+		_, err := a.GetChannelConfig(ctx, key)
+		if !errors.Is(err, ErrAuthFailed) {
+			t.Fatal("should fail with cached auth error:", err)
+		}
+	}
+	if n := handshakes(); n != 1 {
+		t.Fatal("auth attempts are not throttled, handshakes:", n)
+	}
+
+	// window is over
+	a.mx.Lock()
+	a.authFailures[string(key)].retryAt = time.Now()
+	a.mx.Unlock()
+
+	if _, err := a.GetChannelConfig(ctx, key); err == nil {
+		t.Fatal("should fail")
+	}
+	if n := handshakes(); n != 2 {
+		t.Fatal("auth is not retried after backoff, handshakes:", n)
+	}
+
+	a.mx.RLock()
+	f := a.authFailures[string(key)]
+	wait := time.Until(f.retryAt)
+	a.mx.RUnlock()
+	if f.failures != 2 || wait < time.Minute {
+		t.Fatal("backoff is not increased", f.failures, wait)
+	}
+
+	a.authSucceeded(key)
+	if err := a.checkAuthBackoff(key); err != nil {
+		t.Fatal("backoff is not reset:", err)
+	}
+}
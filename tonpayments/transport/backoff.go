@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DHTBackoff - schedule of our dht record updates. After failure, update is retried
+// with exponentially growing delay, randomized by jitter to not synchronize retries of different nodes.
+type DHTBackoff struct {
+	// Interval - delay between successful updates
+	Interval time.Duration
+	// Base - delay before the first retry after failure, doubled on each next failure
+	Base time.Duration
+	// Max - retry delay cap
+	Max time.Duration
+	// Jitter - fraction of delay to randomize, in range [0, 1], zero disables randomization
+	Jitter float64
+}
+
+var DefaultDHTBackoff = DHTBackoff{
+	Interval: 1 * time.Minute,
+	Base:     5 * time.Second,
+	Max:      5 * time.Minute,
+	Jitter:   0.2,
+}
+
+// withDefaults - replaces zero durations with defaults, Max is raised to Base when it is lower
+func (b DHTBackoff) withDefaults() DHTBackoff {
+	if b.Interval <= 0 {
+		b.Interval = DefaultDHTBackoff.Interval
+	}
+	if b.Base <= 0 {
+		b.Base = DefaultDHTBackoff.Base
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultDHTBackoff.Max
+	}
+	if b.Max < b.Base {
+		b.Max = b.Base
+	}
+	if b.Jitter < 0 {
+		b.Jitter = 0
+	} else if b.Jitter > 1 {
+		b.Jitter = 1
+	}
+	return b
+}
+
+// delay - returns wait duration before the next update, failures is a number of failed attempts in a row
+func (b DHTBackoff) delay(failures int) time.Duration {
+	d := b.Interval
+	if failures > 0 {
+		d = b.Base
+		for i := 1; i < failures && d < b.Max; i++ {
+			d *= 2
+		}
+		if d > b.Max {
+			d = b.Max
+		}
+	}
+
+	if b.Jitter > 0 {
+		// random value in range [d-d*jitter, d+d*jitter]
+		d += time.Duration((rand.Float64()*2 - 1) * b.Jitter * float64(d))
+	}
+	return d
+}
@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDHTBackoff_Delay(t *testing.T) {
+	b := DHTBackoff{
+		Interval: time.Minute,
+		Base:     5 * time.Second,
+		Max:      20 * time.Second,
+	}.withDefaults()
+
+	for failures, want := range []time.Duration{time.Minute, 5 * time.Second, 10 * time.Second, 20 * time.Second, 20 * time.Second} {
+		if got := b.delay(failures); got != want {
+			t.Fatal("incorrect delay for", failures, "failures:", got, "want", want)
+		}
+	}
+
+	b.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		got := b.delay(2)
+		if got < 8*time.Second || got > 12*time.Second {
+			t.Fatal("jitter is out of range:", got)
+		}
+	}
+
+	d := DHTBackoff{}.withDefaults()
+	if d.Interval != DefaultDHTBackoff.Interval || d.Base != DefaultDHTBackoff.Base || d.Max != DefaultDHTBackoff.Max {
+		t.Fatal("defaults are not applied", d)
+	}
+}
@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// connectCall - connect to peer in progress, result is available when done is closed
+type connectCall struct {
+	done chan struct{}
+	peer *PeerConnection
+	err  error
+}
+
+// connectShared - connects to peer, concurrent callers for the same key wait for one connect
+// and receive its result, so dht is resolved and peer is authenticated only once.
+// Connect runs with its own timeout, so it is not aborted when the caller which started it gives up,
+// each caller waits for it only till own deadline.
+func (s *Server) connectShared(ctx context.Context, key []byte) (*PeerConnection, error) {
+	s.mx.Lock()
+	call := s.connecting[string(key)]
+	if call == nil {
+		call = &connectCall{done: make(chan struct{})}
+		s.connecting[string(key)] = call
+
+		go func() {
+			connectCtx, cancel := context.WithTimeout(s.closeCtx, s.getTimeouts().ConnectTimeout)
+			defer cancel()
+
+			call.peer, call.err = s.connectPeer(connectCtx, key)
+
+			s.mx.Lock()
+			delete(s.connecting, string(key))
+			s.mx.Unlock()
+			close(call.done)
+		}()
+	}
+	s.mx.Unlock()
+
+	select {
+	case <-call.done:
+		return call.peer, call.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: waiting for connect in progress: %w", ErrPeerUnreachable, ctx.Err())
+	}
+}
+
+func (s *Server) connectPeer(ctx context.Context, key []byte) (*PeerConnection, error) {
+	// fail fast, to not flood party which rejects us
+	if err := s.checkAuthBackoff(key); err != nil {
+		return nil, err
+	}
+
+	release, err := s.acquireConnectSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := s.connect(ctx, key)
+	release()
+	if err != nil {
+		if errors.Is(err, errAuthAttempt) {
+			// node is reached, but handshake is failed
+			s.authFailed(key, err)
+			return nil, fmt.Errorf("%w: failed to connect to peer: %w", ErrAuthFailed, err)
+		}
+		return nil, fmt.Errorf("%w: failed to connect to peer: %w", ErrPeerUnreachable, err)
+	}
+	s.authSucceeded(key)
+
+	return peer, nil
+}
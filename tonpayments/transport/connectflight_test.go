@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_ConcurrentConnect(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if err := a.AddStaticPeer(key, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 10
+	peers := make([]*PeerConnection, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			peers[i], errs[i] = a.preparePeer(ctx, key)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if peers[i] != peers[0] {
+			t.Fatal("callers received different connections")
+		}
+	}
+
+	// address was resolved and connected only once
+	if c := a.AddressStats()[testAddr(b)]; c.Success != 1 || c.Failure != 0 {
+		t.Fatal("incorrect connect attempts", c)
+	}
+	if c := a.QueryStats().Outbound[queryKind(Authenticate{})]; c.Success != 1 {
+		t.Fatal("incorrect auth attempts", c)
+	}
+}
+
+func TestServer_ConnectOutlivesCaller(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if err := a.AddStaticPeer(key, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	// caller gives up before connect is finished
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := a.connectShared(ctx, key); !errors.Is(err, context.Canceled) {
+		t.Fatal("caller should stop waiting on own context:", err)
+	}
+
+	// connect is continued for the next callers
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mx.RLock()
+		peer := a.peersByKey[string(key)]
+		a.mx.RUnlock()
+		if peer != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connect was aborted together with the caller")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestServer_ConnectErrors(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	a.SetQueryRetries(0)
+
+	// nobody listens on this port
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	deadKey, _, _ := ed25519.GenerateKey(nil)
+	deadNode, _, _ := ed25519.GenerateKey(nil)
+	if err = a.AddStaticPeer(deadKey, deadNode, deadAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err = a.GetChannelConfig(ctx, deadKey)
+	if !errors.Is(err, ErrPeerUnreachable) || errors.Is(err, ErrAuthFailed) {
+		t.Fatal("should fail as unreachable:", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("cause is not kept:", err)
+	}
+
+	// party answers our handshake, but we do not accept it
+	b := newTestServer(t, &testService{})
+	a.SetAuthorizer(&testAuthorizer{})
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if err = a.AddStaticPeer(key, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = a.GetChannelConfig(ctx, key)
+	if !errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrPeerUnreachable) {
+		t.Fatal("should fail as not authenticated:", err)
+	}
+}
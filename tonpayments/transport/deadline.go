@@ -0,0 +1,35 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDeadlineTooShort - caller's deadline is not enough to connect and authenticate, so query was not started
+var ErrDeadlineTooShort = errors.New("deadline is too short")
+
+// checkColdDeadline - fails when peer is not connected, has no session to resume,
+// and context deadline leaves less time than connect with handshake usually takes
+func (s *Server) checkColdDeadline(ctx context.Context, key []byte) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+
+	s.mx.RLock()
+	_, connected := s.peersByKey[string(key)]
+	_, hasSession := s.sessions[string(key)]
+	min := s.timeouts.MinColdQueryTime
+	s.mx.RUnlock()
+
+	if connected || hasSession {
+		return nil
+	}
+
+	if left := time.Until(deadline); left < min {
+		return fmt.Errorf("%w: %s left, but at least %s is needed to connect to peer", ErrDeadlineTooShort, left.Round(time.Millisecond), min)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServer_DeadlineTooShort(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{cfg: ChannelConfig{WalletAddr: make([]byte, 32)}})
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if err := a.AddStaticPeer(key, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+	a.SetTimeouts(Timeouts{MinColdQueryTime: 2 * time.Second})
+
+	// cold peer, fails without any network activity
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	tm := time.Now()
+	if _, err := a.GetChannelConfig(ctx, key); !errors.Is(err, ErrDeadlineTooShort) {
+		t.Fatal("should fail with deadline too short:", err)
+	}
+	if time.Since(tm) > 100*time.Millisecond {
+		t.Fatal("not failed fast")
+	}
+	if n := a.PeersCount(); n != 0 {
+		t.Fatal("connection was started")
+	}
+
+	// connected peer, short deadline is fine
+	wctx, wcancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer wcancel()
+	if err := a.Warmup(wctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.GetChannelConfig(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"sync"
+	"time"
+)
+
+const _DefaultDHTCacheTTL = 5 * time.Minute
+
+// resolvedNode - node's adnl key and addresses found in dht by its channel key
+type resolvedNode struct {
+	key   ed25519.PublicKey
+	addrs []string
+}
+
+type dhtCacheEntry struct {
+	node    resolvedNode
+	expires time.Time
+}
+
+// dhtCache - resolved nodes by channel key, to not query dht on every reconnect
+type dhtCache struct {
+	ttl     time.Duration
+	entries map[string]dhtCacheEntry
+	mx      sync.Mutex
+}
+
+func newDHTCache(ttl time.Duration) *dhtCache {
+	return &dhtCache{
+		ttl:     ttl,
+		entries: map[string]dhtCacheEntry{},
+	}
+}
+
+func (c *dhtCache) get(channelKey ed25519.PublicKey) (resolvedNode, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	e, ok := c.entries[string(channelKey)]
+	if !ok {
+		return resolvedNode{}, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, string(channelKey))
+		return resolvedNode{}, false
+	}
+	return e.node, true
+}
+
+func (c *dhtCache) put(channelKey ed25519.PublicKey, node resolvedNode) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries[string(channelKey)] = dhtCacheEntry{
+		node:    node,
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+func (c *dhtCache) invalidate(channelKey ed25519.PublicKey) {
+	c.mx.Lock()
+	delete(c.entries, string(channelKey))
+	c.mx.Unlock()
+}
+
+// SetDHTCacheTTL - how long resolved node addresses are reused for reconnects without dht lookup,
+// zero disables caching. Already cached entries are dropped.
+func (s *Server) SetDHTCacheTTL(ttl time.Duration) {
+	s.dhtCache.mx.Lock()
+	s.dhtCache.ttl = ttl
+	s.dhtCache.entries = map[string]dhtCacheEntry{}
+	s.dhtCache.mx.Unlock()
+}
@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDHTCache(t *testing.T) {
+	key := make(ed25519.PublicKey, 32)
+	node := resolvedNode{key: key, addrs: []string{"127.0.0.1:1"}}
+
+	c := newDHTCache(50 * time.Millisecond)
+	c.put(key, node)
+	if got, ok := c.get(key); !ok || got.addrs[0] != node.addrs[0] {
+		t.Fatal("entry is not cached")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.get(key); ok {
+		t.Fatal("expired entry returned")
+	}
+
+	c.put(key, node)
+	c.invalidate(key)
+	if _, ok := c.get(key); ok {
+		t.Fatal("invalidated entry returned")
+	}
+
+	c = newDHTCache(0)
+	c.put(key, node)
+	if _, ok := c.get(key); ok {
+		t.Fatal("cache should be disabled")
+	}
+}
+
+func TestServer_ConnectCached(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	a.SetTimeouts(Timeouts{ConnectAddressTimeout: 300 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	channelKey := b.channelKey.Public().(ed25519.PublicKey)
+	a.dhtCache.put(channelKey, resolvedNode{key: b.key.Public().(ed25519.PublicKey), addrs: []string{testAddr(b)}})
+
+	// dht is not configured, so connection is possible only using cache
+	peer, err := a.connect(ctx, channelKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !peer.authKey.Equal(channelKey) {
+		t.Fatal("incorrect peer")
+	}
+
+	// stale cached address is invalidated on failed dial
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	c := newTestServer(t, &testService{})
+	c.SetTimeouts(Timeouts{ConnectAddressTimeout: 300 * time.Millisecond})
+	c.dhtCache.put(channelKey, resolvedNode{key: b.key.Public().(ed25519.PublicKey), addrs: []string{dead}})
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err = c.connect(ctx, channelKey); err == nil {
+		t.Fatal("should fail")
+	}
+	if _, ok := c.dhtCache.get(channelKey); ok {
+		t.Fatal("stale entry is not invalidated")
+	}
+}
@@ -0,0 +1,19 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/xssnick/tonutils-go/adnl/address"
+	"github.com/xssnick/tonutils-go/adnl/dht"
+	"time"
+)
+
+// DHTClient - dht methods used by server to publish and resolve node addresses, implemented by *dht.Client
+type DHTClient interface {
+	StoreAddress(ctx context.Context, addresses address.List, ttl time.Duration, ownerKey ed25519.PrivateKey, copies int) (int, []byte, error)
+	FindAddresses(ctx context.Context, key []byte) (*address.List, ed25519.PublicKey, error)
+	Store(ctx context.Context, id any, name []byte, index int32, value []byte, rule any, ttl time.Duration, ownerKey ed25519.PrivateKey, atLeastCopies int) (copiesMade int, idKey []byte, err error)
+	FindValue(ctx context.Context, key *dht.Key, continuation ...*dht.Continuation) (*dht.Value, *dht.Continuation, error)
+}
+
+var _ DHTClient = (*dht.Client)(nil)
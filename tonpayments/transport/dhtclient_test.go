@@ -0,0 +1,204 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/address"
+	"github.com/xssnick/tonutils-go/adnl/dht"
+	"github.com/xssnick/tonutils-go/tl"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDHT - in-memory dht, shared by test servers
+type fakeDHT struct {
+	values    map[string][]byte
+	addresses map[string]fakeDHTAddress
+	finds     int32
+
+	mx sync.Mutex
+}
+
+type fakeDHTAddress struct {
+	list address.List
+	key  ed25519.PublicKey
+}
+
+func newFakeDHT() *fakeDHT {
+	return &fakeDHT{
+		values:    map[string][]byte{},
+		addresses: map[string]fakeDHTAddress{},
+	}
+}
+
+func fakeDHTValueKey(id []byte, name []byte, index int32) string {
+	return fmt.Sprintf("%x/%s/%d", id, name, index)
+}
+
+func (f *fakeDHT) StoreAddress(ctx context.Context, addresses address.List, ttl time.Duration, ownerKey ed25519.PrivateKey, copies int) (int, []byte, error) {
+	pub := ownerKey.Public().(ed25519.PublicKey)
+	id, err := tl.Hash(adnl.PublicKeyED25519{Key: pub})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	f.mx.Lock()
+	f.addresses[string(id)] = fakeDHTAddress{list: addresses, key: pub}
+	f.mx.Unlock()
+	return copies, id, nil
+}
+
+func (f *fakeDHT) FindAddresses(ctx context.Context, key []byte) (*address.List, ed25519.PublicKey, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	a, ok := f.addresses[string(key)]
+	if !ok {
+		return nil, nil, dht.ErrDHTValueIsNotFound
+	}
+	return &a.list, a.key, nil
+}
+
+func (f *fakeDHT) Store(ctx context.Context, id any, name []byte, index int32, value []byte, rule any, ttl time.Duration, ownerKey ed25519.PrivateKey, atLeastCopies int) (int, []byte, error) {
+	idKey, err := tl.Hash(id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	f.mx.Lock()
+	f.values[fakeDHTValueKey(idKey, name, index)] = value
+	f.mx.Unlock()
+	return atLeastCopies, idKey, nil
+}
+
+func (f *fakeDHT) FindValue(ctx context.Context, key *dht.Key, continuation ...*dht.Continuation) (*dht.Value, *dht.Continuation, error) {
+	atomic.AddInt32(&f.finds, 1)
+
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	data, ok := f.values[fakeDHTValueKey(key.ID, key.Name, key.Index)]
+	if !ok {
+		return nil, nil, dht.ErrDHTValueIsNotFound
+	}
+	return &dht.Value{KeyDescription: dht.KeyDescription{Key: *key}, Data: data}, nil, nil
+}
+
+func TestServer_FakeDHT(t *testing.T) {
+	d := newFakeDHT()
+
+	a := newTestServer(t, &testService{})
+	a.dht = d
+	b := newTestServer(t, &testService{cfg: ChannelConfig{WalletAddr: make([]byte, 32)}})
+	b.dht = d
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if _, err := a.GetChannelConfig(ctx, key); err == nil {
+		t.Fatal("b is not published yet, should fail")
+	}
+
+	if err := b.updateDHT(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a resolves b using its published records
+	if _, err := a.GetChannelConfig(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&d.finds); n != 2 {
+		t.Fatal("incorrect dht lookups", n)
+	}
+}
+
+func TestServer_ResolveIPv6(t *testing.T) {
+	d := newFakeDHT()
+
+	a := newTestServer(t, &testService{})
+	a.dht = d
+	b := newTestServer(t, &testService{cfg: ChannelConfig{WalletAddr: make([]byte, 32)}})
+	b.dht = d
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := b.updateDHT(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// replace published address with ipv6 one
+	d.mx.Lock()
+	for id, rec := range d.addresses {
+		rec.list.Addresses = []*address.UDP{{IP: net.ParseIP("fe80::1"), Port: 4567}}
+		d.addresses[id] = rec
+	}
+	d.mx.Unlock()
+
+	node, err := a.resolve(ctx, b.channelKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(node.addrs) != 1 || node.addrs[0] != "[fe80::1]:4567" {
+		t.Fatal("incorrect dial address", node.addrs)
+	}
+
+	host, port, err := net.SplitHostPort(node.addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "fe80::1" || port != "4567" {
+		t.Fatal("incorrect host or port", host, port)
+	}
+}
+
+// hangingDHT - dht which stores values only when ctx is done, like unresponsive dht node
+type hangingDHT struct {
+	*fakeDHT
+	entered chan struct{}
+}
+
+func (h *hangingDHT) Store(ctx context.Context, id any, name []byte, index int32, value []byte, rule any, ttl time.Duration, ownerKey ed25519.PrivateKey, atLeastCopies int) (int, []byte, error) {
+	close(h.entered)
+	<-ctx.Done()
+	return 0, nil, ctx.Err()
+}
+
+func TestServer_UpdateDHT_Cancel(t *testing.T) {
+	d := &hangingDHT{fakeDHT: newFakeDHT(), entered: make(chan struct{})}
+
+	s := newTestServer(t, &testService{})
+	s.dht = d
+
+	ctx, cancel := context.WithTimeout(s.closeCtx, _DHTUpdateTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.updateDHT(ctx)
+	}()
+
+	select {
+	case <-d.entered:
+	case <-time.After(3 * time.Second):
+		t.Fatal("update is not reached value store")
+	}
+
+	_ = s.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal("should be cancelled:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("update is not aborted by close")
+	}
+}
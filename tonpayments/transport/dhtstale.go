@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// _DHTRecordTTL - how long our dht records live without refresh
+const _DHTRecordTTL = 10 * time.Minute
+
+// dhtStaleness - tracks time since the last successful dht store,
+// to warn operator before our record expires and we become undiscoverable
+type dhtStaleness struct {
+	lastStored time.Time
+	// last reported warning level, warnings are escalated, not repeated
+	level   int
+	onStale func(sinceStore, remaining time.Duration)
+
+	mx sync.Mutex
+}
+
+// SetOnDHTRecordStale - sets hook which is called when our dht record is not refreshed for a long time,
+// first when half of its TTL has passed, then when less than a fifth is left, and when it is expired.
+// Hook is called from dht updater goroutine, so it must not block.
+func (s *Server) SetOnDHTRecordStale(f func(sinceStore, remaining time.Duration)) {
+	s.dhtStale.mx.Lock()
+	s.dhtStale.onStale = f
+	s.dhtStale.mx.Unlock()
+}
+
+// observe - records result of dht update attempt, and escalates warning when record is close to expiration
+func (d *dhtStaleness) observe(now time.Time, err error) {
+	d.mx.Lock()
+	if err == nil {
+		d.lastStored = now
+		d.level = 0
+		d.mx.Unlock()
+		return
+	}
+
+	if d.lastStored.IsZero() {
+		// nothing was stored yet, so nothing can expire
+		d.mx.Unlock()
+		return
+	}
+
+	since := now.Sub(d.lastStored)
+	remaining := _DHTRecordTTL - since
+
+	level := 0
+	switch {
+	case remaining <= 0:
+		level = 3
+	case remaining <= _DHTRecordTTL/5:
+		level = 2
+	case remaining <= _DHTRecordTTL/2:
+		level = 1
+	}
+
+	if level <= d.level {
+		d.mx.Unlock()
+		return
+	}
+	d.level = level
+	hook := d.onStale
+	d.mx.Unlock()
+
+	ev := log.Warn()
+	msg := "our dht record is not refreshed for a long time"
+	switch level {
+	case 2:
+		ev = log.Error()
+		msg = "our dht record is about to expire"
+	case 3:
+		ev = log.Error()
+		msg = "our dht record is expired, node is not discoverable"
+	}
+	ev.Err(err).Str("source", "server").Dur("since_store", since).Dur("remaining", remaining).Msg(msg)
+
+	if hook != nil {
+		hook(since, remaining)
+	}
+}
+
+// lastStoredAt - time of the last successful dht store, zero when nothing was stored
+func (d *dhtStaleness) lastStoredAt() time.Time {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.lastStored
+}
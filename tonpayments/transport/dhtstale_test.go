@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestServer_DHTRecordStale(t *testing.T) {
+	s := newTestServer(t, &testService{})
+
+	var calls []time.Duration
+	s.SetOnDHTRecordStale(func(sinceStore, remaining time.Duration) {
+		calls = append(calls, remaining)
+	})
+
+	start := time.Now()
+	failed := fmt.Errorf("store failed")
+
+	// failures before the first store are not reported, there is no record yet
+	s.dhtStale.observe(start, failed)
+	s.dhtStale.observe(start, nil)
+
+	// repeated failures, warnings are escalated when TTL is approaching
+	for _, passed := range []time.Duration{time.Minute, 4 * time.Minute, 6 * time.Minute, 7 * time.Minute,
+		9 * time.Minute, 9*time.Minute + 30*time.Second, 11 * time.Minute, 12 * time.Minute} {
+		s.dhtStale.observe(start.Add(passed), failed)
+	}
+
+	if len(calls) != 3 {
+		t.Fatal("incorrect warnings number", len(calls), calls)
+	}
+	if calls[0] != 4*time.Minute || calls[1] != time.Minute || calls[2] != -time.Minute {
+		t.Fatal("incorrect remaining time", calls)
+	}
+
+	// successful store resets tracking
+	s.dhtStale.observe(start.Add(13*time.Minute), nil)
+	s.dhtStale.observe(start.Add(14*time.Minute), failed)
+	if len(calls) != 3 {
+		t.Fatal("warning after successful store")
+	}
+	s.dhtStale.observe(start.Add(19*time.Minute), failed)
+	if len(calls) != 4 {
+		t.Fatal("warning is not emitted after reset")
+	}
+}
@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// TriggerDHTUpdate - requests immediate update of our dht record, for example after our address has changed.
+// Triggers during running update are coalesced into one more run after it, and the regular
+// schedule is counted from the forced update. Does nothing when server is not in server mode.
+func (s *Server) TriggerDHTUpdate() {
+	select {
+	case s.dhtTrigger <- struct{}{}:
+	default:
+		// update is already requested
+	}
+}
+
+// dhtUpdater - keeps our dht record fresh until server is closed
+func (s *Server) dhtUpdater(backoff DHTBackoff) {
+	wait := 1 * time.Second
+	failures := 0
+	// refresh dht records
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			log.Info().Str("source", "server").Msg("stopped dht updater")
+			return
+		case <-time.After(wait):
+		case <-s.dhtTrigger:
+			log.Debug().Str("source", "server").Msg("forced dht record update")
+		}
+
+		log.Debug().Str("source", "server").Msg("updating our dht record")
+
+		ctx, cancel := context.WithTimeout(s.closeCtx, _DHTUpdateTimeout)
+		err := s.updateDHT(ctx)
+		cancel()
+		s.dhtStale.observe(time.Now(), err)
+
+		if err != nil {
+			// on err, retry sooner, but backoff to not overload dht when it is flaky
+			failures++
+			wait = backoff.delay(failures)
+
+			log.Warn().Err(err).Str("source", "server").Dur("retry_in", wait).Msg("failed to update our dht record")
+			continue
+		}
+		failures = 0
+		wait = backoff.delay(0)
+	}
+}
@@ -0,0 +1,34 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServer_TriggerDHTUpdate(t *testing.T) {
+	s := newTestServer(t, &testService{})
+	s.dht = newFakeDHT()
+	go s.dhtUpdater(DHTBackoff{Interval: time.Hour}.withDefaults())
+
+	waitStored := func(after time.Time) time.Time {
+		deadline := time.Now().Add(500 * time.Millisecond)
+		for time.Now().Before(deadline) {
+			if at := s.dhtStale.lastStoredAt(); at.After(after) {
+				return at
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("dht record is not updated")
+		return time.Time{}
+	}
+
+	// forced update goes before the initial delay
+	s.TriggerDHTUpdate()
+	first := waitStored(time.Time{})
+
+	// multiple triggers are coalesced and do not block
+	s.TriggerDHTUpdate()
+	s.TriggerDHTUpdate()
+	s.TriggerDHTUpdate()
+	waitStored(first)
+}
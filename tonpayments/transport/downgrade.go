@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// max number of parties which capabilities are remembered
+const _MaxKnownCapabilities = 4096
+
+// peerCapabilities - auth algorithms advertised by parties in their last handshake, by channel key
+type peerCapabilities struct {
+	list map[string][]int32
+	mx   sync.Mutex
+}
+
+// update - remembers advertised algorithms of the party, returns previously advertised ones which are now missing
+func (c *peerCapabilities) update(key []byte, supported []int32) (prev, missing []int32) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.list == nil {
+		c.list = map[string][]int32{}
+	}
+
+	prev, ok := c.list[string(key)]
+	if !ok && len(c.list) >= _MaxKnownCapabilities {
+		return nil, nil
+	}
+	c.list[string(key)] = append([]int32{}, supported...)
+
+	for _, p := range prev {
+		found := false
+		for _, a := range supported {
+			if a == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, p)
+		}
+	}
+	return prev, missing
+}
+
+// SetOnDowngrade - sets hook which is called when party stops advertising auth algorithms it supported
+// in the previous handshake. It can be a legit update of the party, but also a sign of tampering,
+// when someone in the middle tries to force weaker algorithm. Connection is not dropped.
+func (s *Server) SetOnDowngrade(f func(channelKey ed25519.PublicKey, prev, now []int32)) {
+	s.mx.Lock()
+	s.onDowngrade = f
+	s.mx.Unlock()
+}
+
+// checkDowngrade - compares advertised algorithms of the party with the previous handshake and reports regression
+func (s *Server) checkDowngrade(auth *Authenticate) {
+	prev, missing := s.capabilities.update(auth.Key, auth.Supported)
+	if len(missing) == 0 {
+		return
+	}
+
+	s.peerLog(log.Warn().Str("source", "server"), auth.Key).
+		Ints32("prev", prev).Ints32("now", auth.Supported).
+		Msg("party stopped advertising previously supported auth algorithms, possible downgrade attempt")
+
+	s.mx.RLock()
+	hook := s.onDowngrade
+	s.mx.RUnlock()
+
+	if hook != nil {
+		hook(append(ed25519.PublicKey{}, auth.Key...), prev, append([]int32{}, auth.Supported...))
+	}
+}
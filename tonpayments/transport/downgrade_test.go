@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func TestServer_OnDowngrade(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	type alert struct {
+		key       ed25519.PublicKey
+		prev, now []int32
+	}
+	alerts := make(chan alert, 1)
+	b.SetOnDowngrade(func(channelKey ed25519.PublicKey, prev, now []int32) {
+		alerts <- alert{channelKey, prev, now}
+	})
+
+	// in the previous session party advertised one more algorithm
+	aKey := a.channelKey.Public().(ed25519.PublicKey)
+	b.capabilities.update(aKey, []int32{AuthAlgorithmEd25519, 7})
+
+	connectTestServers(t, a, b)
+
+	select {
+	case al := <-alerts:
+		if !bytes.Equal(al.key, aKey) {
+			t.Fatal("incorrect key")
+		}
+		if len(al.prev) != 2 || len(al.now) != 1 || al.now[0] != AuthAlgorithmEd25519 {
+			t.Fatal("incorrect algorithms", al.prev, al.now)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("downgrade is not reported")
+	}
+}
+
+func TestPeerCapabilities_Update(t *testing.T) {
+	var c peerCapabilities
+	key := []byte("key")
+
+	if _, missing := c.update(key, []int32{0}); missing != nil {
+		t.Fatal("first handshake cannot be a downgrade")
+	}
+	if _, missing := c.update(key, []int32{1, 0}); missing != nil {
+		t.Fatal("upgrade is not a downgrade")
+	}
+	if _, missing := c.update(key, []int32{0}); len(missing) != 1 || missing[0] != 1 {
+		t.Fatal("downgrade is not detected", missing)
+	}
+	if _, missing := c.update(key, []int32{0}); missing != nil {
+		t.Fatal("same algorithms are not a downgrade")
+	}
+}
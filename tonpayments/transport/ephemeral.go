@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"github.com/xssnick/tonutils-go/tl"
+	"sync"
+	"time"
+)
+
+// ephemeralSigner - short-lived auth signing key, certified by channel key.
+// Key is rotated every period, certificate is valid for 2 periods,
+// so handshakes which are in progress during rotation can still be verified.
+type ephemeralSigner struct {
+	period   time.Duration
+	key      ed25519.PrivateKey
+	cert     *EphemeralCert
+	rotateAt time.Time
+
+	mx sync.Mutex
+}
+
+// SetEphemeralAuthKey - when period > 0, auth messages will be signed with ephemeral key,
+// rotated every period and certified by channel key. Zero period disables it.
+func (s *Server) SetEphemeralAuthKey(period time.Duration) {
+	var signer *ephemeralSigner
+	if period > 0 {
+		signer = &ephemeralSigner{period: period}
+	}
+
+	s.mx.Lock()
+	s.ephemeral = signer
+	s.mx.Unlock()
+}
+
+// get - returns current ephemeral key with its certificate, rotates it when needed
+func (e *ephemeralSigner) get(channelKey ed25519.PrivateKey) (ed25519.PrivateKey, *EphemeralCert, error) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	now := time.Now()
+	if e.key != nil && now.Before(e.rotateAt) {
+		return e.key, e.cert, nil
+	}
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+
+	validUntil := now.Add(2 * e.period).Unix()
+	toSign, err := tl.Hash(EphemeralCertToSign{
+		ChannelKey: channelKey.Public().(ed25519.PublicKey),
+		Key:        pub,
+		ValidUntil: validUntil,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to hash ephemeral cert: %s", ErrInternal, err.Error())
+	}
+
+	e.key = key
+	e.rotateAt = now.Add(e.period)
+	e.cert = &EphemeralCert{
+		Key:        pub,
+		ValidUntil: validUntil,
+		Signature:  ed25519.Sign(channelKey, toSign),
+	}
+	return e.key, e.cert, nil
+}
+
+// signAuth - builds auth message signed by channel key, or by ephemeral key when it is enabled
+func (s *Server) signAuth(algorithm int32, authData []byte, ts int64) (Authenticate, error) {
+	if err := checkAuthAlgorithm(algorithm); err != nil {
+		return Authenticate{}, err
+	}
+
+	s.mx.RLock()
+	signer := s.ephemeral
+	s.mx.RUnlock()
+
+	res := Authenticate{
+		Key:       s.channelKey.Public().(ed25519.PublicKey),
+		Timestamp: ts,
+		Algorithm: algorithm,
+		Supported: supportedAuthAlgorithms,
+	}
+
+	if signer == nil {
+		res.Signature = ed25519.Sign(s.channelKey, authData)
+		return res, nil
+	}
+
+	key, cert, err := signer.get(s.channelKey)
+	if err != nil {
+		return Authenticate{}, err
+	}
+
+	res.Flags |= 1
+	res.Cert = cert
+	res.Signature = ed25519.Sign(key, authData)
+	return res, nil
+}
+
+// verifyAuth - checks auth signature, when certificate is attached, checks it using chain
+func verifyAuth(auth *Authenticate, authData []byte) error {
+	if auth.Algorithm != AuthAlgorithmEd25519 {
+		return fmt.Errorf("unknown auth signature algorithm %d", auth.Algorithm)
+	}
+	signer := ed25519.PublicKey(auth.Key)
+
+	if auth.Cert != nil {
+		if auth.Cert.ValidUntil < time.Now().Unix() {
+			return fmt.Errorf("ephemeral key certificate expired")
+		}
+
+		toSign, err := tl.Hash(EphemeralCertToSign{
+			ChannelKey: auth.Key,
+			Key:        auth.Cert.Key,
+			ValidUntil: auth.Cert.ValidUntil,
+		})
+		if err != nil {
+			return fmt.Errorf("%w: failed to hash ephemeral cert: %s", ErrInternal, err.Error())
+		}
+
+		if !ed25519.Verify(auth.Key, toSign, auth.Cert.Signature) {
+			return fmt.Errorf("incorrect ephemeral key certificate signature")
+		}
+		signer = auth.Cert.Key
+	}
+
+	if !ed25519.Verify(signer, authData, auth.Signature) {
+		return fmt.Errorf("incorrect signature")
+	}
+	return nil
+}
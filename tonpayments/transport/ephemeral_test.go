@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func TestServer_EphemeralAuthKey(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	a.SetEphemeralAuthKey(time.Minute)
+	b.SetEphemeralAuthKey(time.Minute)
+
+	// both sides verify each other's chain
+	peer := connectTestServers(t, a, b)
+	if !bytes.Equal(peer.authKey, b.channelKey.Public().(ed25519.PublicKey)) {
+		t.Fatal("incorrect auth key")
+	}
+}
+
+func TestEphemeralSigner_Rotation(t *testing.T) {
+	s := newTestServer(t, &testService{})
+	s.SetEphemeralAuthKey(time.Minute)
+
+	data := []byte("auth data")
+
+	first, err := s.signAuth(AuthAlgorithmEd25519, data, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Cert == nil {
+		t.Fatal("cert is not attached")
+	}
+	if err = verifyAuth(&first, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// force rotation
+	s.ephemeral.mx.Lock()
+	s.ephemeral.rotateAt = time.Now().Add(-time.Second)
+	s.ephemeral.mx.Unlock()
+
+	second, err := s.signAuth(AuthAlgorithmEd25519, data, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first.Cert.Key, second.Cert.Key) {
+		t.Fatal("key was not rotated")
+	}
+	if err = verifyAuth(&second, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// previous cert is still valid, to not break handshakes in progress
+	if err = verifyAuth(&first, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// cert signed not by channel key must be rejected
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	forged := second
+	forged.Key = otherKey.Public().(ed25519.PublicKey)
+	if err = verifyAuth(&forged, data); err == nil {
+		t.Fatal("forged chain accepted")
+	}
+
+	expired := second
+	cert := *second.Cert
+	cert.ValidUntil = time.Now().Add(-time.Second).Unix()
+	expired.Cert = &cert
+	if err = verifyAuth(&expired, data); err == nil {
+		t.Fatal("expired cert accepted")
+	}
+}
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"github.com/rs/zerolog/log"
+	"sync/atomic"
+	"time"
+)
+
+// SetIdleTimeout - closes authenticated peers which have no queries in both directions
+// for longer than timeout, keepalive pings are not counted as activity. Zero disables it.
+func (s *Server) SetIdleTimeout(timeout time.Duration) {
+	s.mx.Lock()
+	s.idleTimeout = timeout
+	start := timeout > 0 && !s.idleSweeper
+	if start {
+		s.idleSweeper = true
+	}
+	s.mx.Unlock()
+
+	if start {
+		go s.idleSweep()
+	}
+}
+
+// touch - marks peer as active
+func (p *PeerConnection) touch() {
+	atomic.StoreInt64(&p.lastActivity, time.Now().UnixNano())
+}
+
+// markSuccess - marks that query with peer in any direction succeeded
+func (p *PeerConnection) markSuccess() {
+	atomic.StoreInt64(&p.lastSuccess, time.Now().UnixNano())
+}
+
+// LastSuccess - returns time of the last successful query with peer in any direction,
+// excluding keepalive pings, zero when there were no successful queries yet
+func (p *PeerConnection) LastSuccess() time.Time {
+	ns := atomic.LoadInt64(&p.lastSuccess)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (p *PeerConnection) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&p.lastActivity)))
+}
+
+func (s *Server) idleSweep() {
+	for {
+		s.mx.RLock()
+		timeout := s.idleTimeout
+		s.mx.RUnlock()
+
+		wait := timeout / 4
+		if wait <= 0 || wait > time.Minute {
+			wait = time.Minute
+		}
+
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if timeout <= 0 {
+			continue
+		}
+
+		now := time.Now()
+		var idle []*PeerConnection
+		var keys [][]byte
+
+		s.mx.RLock()
+		for _, p := range s.peersByKey {
+			if p.ActiveTransfers() == 0 && p.idleFor(now) > timeout {
+				idle = append(idle, p)
+				keys = append(keys, p.authKey)
+			}
+		}
+		s.mx.RUnlock()
+
+		for i, p := range idle {
+			s.peerLog(log.Info().Str("source", "server"), keys[i]).Msg("closing idle peer connection")
+			p.adnl.Close()
+		}
+	}
+}
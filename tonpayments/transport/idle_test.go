@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_IdleTimeout(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	// pings should not keep connection alive
+	a.SetKeepalive(50*time.Millisecond, 3)
+	a.SetIdleTimeout(300 * time.Millisecond)
+
+	peer := connectTestServers(t, a, b)
+
+	select {
+	case <-peer.stop:
+	case <-time.After(3 * time.Second):
+		t.Fatal("idle peer was not closed")
+	}
+
+	if peer.idleFor(time.Now()) < 300*time.Millisecond {
+		t.Fatal("peer was closed before idle timeout")
+	}
+}
+
+func TestServer_LastSuccess(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	a.SetQueryRetries(0)
+	b := newTestServer(t, &testService{})
+
+	peer := connectTestServers(t, a, b)
+	if !peer.LastSuccess().IsZero() {
+		t.Fatal("no queries were made yet")
+	}
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := a.GetChannelConfig(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	success := peer.LastSuccess()
+	if success.IsZero() {
+		t.Fatal("success is not tracked")
+	}
+
+	var inbound PeerInfo
+	for _, p := range b.ListPeers() {
+		if bytes.Equal(p.AuthKey, a.channelKey.Public().(ed25519.PublicKey)) {
+			inbound = p
+		}
+	}
+	if inbound.LastSuccess.IsZero() {
+		t.Fatal("success of inbound query is not tracked")
+	}
+
+	// party fails to get shared channels and does not answer
+	before := time.Now()
+	failCtx, failCancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer failCancel()
+	if _, err := a.GetSharedChannels(failCtx, key); err == nil {
+		t.Fatal("query should fail")
+	}
+
+	if !peer.LastSuccess().Equal(success) {
+		t.Fatal("failed query updated last success")
+	}
+	if time.Unix(0, atomic.LoadInt64(&peer.lastActivity)).Before(before) {
+		t.Fatal("failed query should be counted as activity")
+	}
+}
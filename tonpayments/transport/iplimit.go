@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrTooManyConnections - source ip has reached its limit of connections
+var ErrTooManyConnections = errors.New("too many connections from ip")
+
+// ipUsage - connections from one source ip, and their shared query limiter
+type ipUsage struct {
+	conns   int
+	limiter *tokenBucket
+}
+
+// SetIPLimits - limits inbound connections and queries aggregated by source ip, so one host
+// cannot multiply per peer limits by opening many connections. Zero maxConnections and
+// zero Rate mean no limit. Applied to new connections only.
+func (s *Server) SetIPLimits(maxConnections int, queries RateLimit) {
+	s.mx.Lock()
+	s.ipMaxConns = maxConnections
+	s.ipLimit = queries
+	s.mx.Unlock()
+}
+
+// SetOnConnectionRejected - sets hook which is called when connection is refused or closed because of limits,
+// reason is ErrTooManyConnections or ErrPeerEvicted, addr is remote address of the connection.
+// Hook is called from connection accepting goroutine, so it must not block.
+func (s *Server) SetOnConnectionRejected(f func(reason error, addr string)) {
+	s.mx.Lock()
+	s.onConnectionRejected = f
+	s.mx.Unlock()
+}
+
+// remoteIP - host part of peer address, whole address when it cannot be split
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// acquireIP - counts connection from ip, returns limiter shared by all its connections.
+// Inbound connection is refused when ip has reached the limit. Must be called under lock.
+func (s *Server) acquireIP(ip string, inbound bool) (*tokenBucket, error) {
+	u := s.ipUsage[ip]
+	if inbound && s.ipMaxConns > 0 && u != nil && u.conns >= s.ipMaxConns {
+		return nil, ErrTooManyConnections
+	}
+
+	if u == nil {
+		u = &ipUsage{limiter: newTokenBucket(s.ipLimit)}
+		s.ipUsage[ip] = u
+	}
+	u.conns++
+	return u.limiter, nil
+}
+
+// releaseIP - forgets connection from ip, must be called under lock
+func (s *Server) releaseIP(ip string) {
+	u := s.ipUsage[ip]
+	if u == nil {
+		return
+	}
+
+	u.conns--
+	if u.conns <= 0 {
+		delete(s.ipUsage, ip)
+	}
+}
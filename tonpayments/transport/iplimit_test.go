@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"github.com/xssnick/tonutils-go/address"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestServer_IPRateLimit(t *testing.T) {
+	b := newTestServer(t, &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			return nil
+		},
+	})
+	// auth queries of both connections take 2 tokens
+	b.SetIPLimits(0, RateLimit{Rate: 0.001, Burst: 4})
+
+	// all test servers are on 127.0.0.1
+	a1 := newTestServer(t, &testService{})
+	a2 := newTestServer(t, &testService{})
+	connectTestServers(t, a1, b)
+	connectTestServers(t, a2, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request := func(from *Server) error {
+		_, err := from.RequestInboundChannel(ctx, big.NewInt(1000), nil, address.NewAddress(0, 0, make([]byte, 32)),
+			from.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+		return err
+	}
+
+	if err := request(a1); err != nil {
+		t.Fatal(err)
+	}
+	if err := request(a2); err != nil {
+		t.Fatal(err)
+	}
+
+	// per connection limit is not set, but ip allowance is spent by both connections
+	for _, from := range []*Server{a1, a2} {
+		var retryErr *RetryableError
+		if err := request(from); !errors.As(err, &retryErr) || !errors.Is(err, ErrRateLimited) {
+			t.Fatal("query is not rate limited:", err)
+		}
+	}
+}
+
+func TestServer_IPMaxConnections(t *testing.T) {
+	rejected := make(chan error, 1)
+	b := newTestServer(t, &testService{}, func(s *Server) {
+		s.SetOnConnectionRejected(func(reason error, addr string) {
+			select {
+			case rejected <- reason:
+			default:
+			}
+		})
+	})
+	b.SetIPLimits(1, RateLimit{})
+
+	a1 := newTestServer(t, &testService{})
+	connectTestServers(t, a1, b)
+
+	a2 := newTestServer(t, &testService{})
+	client, err := a2.gate.RegisterClient(testAddr(b), b.key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err = a2.auth(ctx, a2.bootstrapPeer(client)); err == nil {
+		t.Fatal("second connection from ip should be refused")
+	}
+	if n := b.PeersCount(); n != 1 {
+		t.Fatal("incorrect peers count", n)
+	}
+	select {
+	case err = <-rejected:
+		if !errors.Is(err, ErrTooManyConnections) {
+			t.Fatal("incorrect rejection reason", err)
+		}
+	default:
+		t.Fatal("rejection hook is not called")
+	}
+
+	// slot is released on disconnect
+	for _, p := range b.ListPeers() {
+		b.mx.RLock()
+		peer := b.peers[string(p.ADNLID)]
+		b.mx.RUnlock()
+		peer.adnl.Close()
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for b.PeersCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("peer is not disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	connectTestServers(t, newTestServer(t, &testService{}), b)
+}
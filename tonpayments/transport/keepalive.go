@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"sync/atomic"
+	"time"
+)
+
+// SetKeepalive - when interval > 0, every new peer connection will be pinged with this interval,
+// and closed after maxMissed consecutive pings without answer. Zero interval disables it.
+// Already established connections are not affected.
+func (s *Server) SetKeepalive(interval time.Duration, maxMissed int) {
+	if maxMissed <= 0 {
+		maxMissed = 1
+	}
+
+	s.mx.Lock()
+	s.keepaliveInterval = interval
+	s.keepaliveMaxMissed = maxMissed
+	s.mx.Unlock()
+}
+
+// RTT - returns round trip time measured by the last successful keepalive ping, zero if not measured yet
+func (p *PeerConnection) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.rtt))
+}
+
+// keepalive - pings peer until it is disconnected, closes connection when too many pings are missed
+func (s *Server) keepalive(p *PeerConnection, interval time.Duration, maxMissed int) {
+	missed := 0
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-time.After(interval):
+		}
+
+		rtt, err := s.ping(p, interval)
+		if err != nil {
+			missed++
+			log.Debug().Err(err).Str("source", "server").Int("missed", missed).Msg("keepalive ping failed")
+
+			if missed >= maxMissed {
+				// key is bound under server lock
+				s.mx.RLock()
+				key := p.authKey
+				s.mx.RUnlock()
+
+				s.peerLog(log.Info().Str("source", "server"), key).Msg("peer is not responding to pings, closing connection")
+				p.adnl.Close()
+				return
+			}
+			continue
+		}
+
+		missed = 0
+		atomic.StoreInt64(&p.rtt, int64(rtt))
+	}
+}
+
+func (s *Server) ping(p *PeerConnection, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(s.closeCtx, timeout)
+	defer cancel()
+
+	atomic.AddInt32(&p.activeTransfers, 1)
+	defer atomic.AddInt32(&p.activeTransfers, -1)
+
+	tm := time.Now()
+
+	var res Pong
+	if err := doTypedQuery(ctx, p.rldp, _RLDPMaxAnswerSize, Ping{Timestamp: tm.UnixNano()}, &res); err != nil {
+		return 0, err
+	}
+	return time.Since(tm), nil
+}
@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServer_Keepalive(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	a.SetKeepalive(100*time.Millisecond, 2)
+
+	peer := connectTestServers(t, a, b)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for peer.RTT() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("rtt was not measured")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if list := a.ListPeers(); len(list) != 1 || list[0].RTT <= 0 {
+		t.Fatal("rtt is not listed", list)
+	}
+
+	// peer stops responding
+	_ = b.gate.Close()
+
+	select {
+	case <-peer.stop:
+	case <-time.After(3 * time.Second):
+		t.Fatal("peer was not closed after missed pings")
+	}
+
+	a.mx.RLock()
+	_, ok := a.peers[string(peer.adnl.GetID())]
+	a.mx.RUnlock()
+	if ok {
+		t.Fatal("peer was not removed")
+	}
+}
+
+func TestServer_KeepaliveBypassesLimits(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	// auth query takes the only token, so any other query is rate limited
+	b.SetRateLimits(RateLimit{Rate: 0.001, Burst: 1}, RateLimit{Rate: 0.001, Burst: 1})
+	peer := connectTestServers(t, a, b)
+
+	for i := 0; i < 5; i++ {
+		if _, err := a.ping(peer, 3*time.Second); err != nil {
+			t.Fatal("ping is not answered:", err)
+		}
+	}
+}
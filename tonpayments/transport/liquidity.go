@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// _DefaultLiquidityRetryAfter - retry hint when service has not estimated when liquidity frees up
+const _DefaultLiquidityRetryAfter = time.Minute
+
+// InsufficientLiquidityError - inbound channel cannot be deployed now because our capacity is exhausted,
+// unlike other rejections it is transient, and request can be repeated after RetryAfter.
+// Returned by service from ProcessInboundChannelRequest, and by RequestInboundChannel on the requester side.
+type InsufficientLiquidityError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *InsufficientLiquidityError) Error() string {
+	return fmt.Sprintf("insufficient liquidity, retry after %s: %s", e.RetryAfter, e.Reason)
+}
+
+// inboundDecision - builds answer for inbound channel request, capacity exhaustion is reported with retry hint
+func inboundDecision(err error) Decision {
+	if err == nil {
+		return Decision{Agreed: true}
+	}
+
+	res := Decision{Reason: err.Error()}
+
+	var liq *InsufficientLiquidityError
+	if errors.As(err, &liq) {
+		retryAfter := liq.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = _DefaultLiquidityRetryAfter
+		}
+
+		res.Flags |= 2
+		res.Reason = liq.Reason
+		res.RetryAfter = int64((retryAfter + time.Second - 1) / time.Second)
+	}
+	return res
+}
+
+// inboundDecisionError - converts rejection of inbound channel request to error
+func inboundDecisionError(res *Decision) error {
+	if res.Flags&2 != 0 {
+		if err := retryableRejection(res.Reason, res.RetryAfter); err != nil {
+			return err
+		}
+
+		return &InsufficientLiquidityError{
+			Reason:     res.Reason,
+			RetryAfter: time.Duration(res.RetryAfter) * time.Second,
+		}
+	}
+	return &DecisionError{Reason: res.Reason}
+}
@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/address"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_RequestInboundChannel_InsufficientLiquidity(t *testing.T) {
+	a := newTestServer(t, &testService{})
+
+	var svcErr atomic.Pointer[error]
+	setErr := func(err error) {
+		svcErr.Store(&err)
+	}
+	b := newTestServer(t, &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			return *svcErr.Load()
+		},
+	})
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request := func() error {
+		_, err := a.RequestInboundChannel(ctx, big.NewInt(1e9), nil, address.NewAddress(0, 0, make([]byte, 32)),
+			a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+		return err
+	}
+
+	setErr(fmt.Errorf("failed to check capacity: %w", &InsufficientLiquidityError{Reason: "no free capacity", RetryAfter: 1500 * time.Millisecond}))
+
+	var liq *InsufficientLiquidityError
+	if err := request(); !errors.As(err, &liq) {
+		t.Fatal("should be insufficient liquidity:", err)
+	}
+	if liq.RetryAfter != 2*time.Second || liq.Reason != "no free capacity" {
+		t.Fatal("incorrect retry hint", liq.RetryAfter, liq.Reason)
+	}
+
+	// retry hint is always present
+	setErr(&InsufficientLiquidityError{Reason: "no free capacity"})
+	if err := request(); !errors.As(err, &liq) || liq.RetryAfter != _DefaultLiquidityRetryAfter {
+		t.Fatal("should be insufficient liquidity with default retry hint:", err)
+	}
+
+	// permanent rejection
+	setErr(fmt.Errorf("minimum capacity is 1 TON"))
+	err := request()
+	var decErr *DecisionError
+	if !errors.As(err, &decErr) || errors.As(err, &liq) {
+		t.Fatal("should be permanent rejection:", err)
+	}
+}
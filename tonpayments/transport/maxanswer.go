@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl/rldp"
+	"github.com/xssnick/tonutils-go/tl"
+)
+
+// SetMaxAnswerSize - overrides max answer size we accept for query type, like GetChannelConfig{},
+// when party's answers can be larger than default. Zero or negative size restores default.
+func (s *Server) SetMaxAnswerSize(query tl.Serializable, size int64) {
+	kind := queryKind(query)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if size <= 0 {
+		delete(s.maxAnswerSizes, kind)
+	} else {
+		s.maxAnswerSizes[kind] = size
+	}
+	s.updateMessageSizeLimit()
+}
+
+// maxAnswerSize - returns max answer size for query
+func (s *Server) maxAnswerSize(query tl.Serializable) int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	if size, ok := s.maxAnswerSizes[queryKind(query)]; ok {
+		return size
+	}
+	return _RLDPMaxAnswerSize
+}
+
+// tooLargeAnswer - checks if answer was not sent because of size requested by the party,
+// in this case rejection can be sent instead, so party will know the reason.
+func tooLargeAnswer(query *rldp.Query, answer tl.Serializable) (bool, error) {
+	data, err := tl.Serialize(rldp.Answer{ID: query.ID, Data: answer}, true)
+	if err != nil {
+		return false, fmt.Errorf("failed to serialize answer: %w", err)
+	}
+	return int64(len(data)) > query.MaxAnswerSize, nil
+}
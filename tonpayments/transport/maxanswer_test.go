@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestServer_MaxAnswerSize(t *testing.T) {
+	// config with large embedded data, which is not fit into restricted answer size
+	svc := &testService{
+		cfg: ChannelConfig{
+			ExcessFee:   bytes.Repeat([]byte{0x7F}, 20000),
+			WalletAddr:  make([]byte, 32),
+			MinCapacity: big.NewInt(100).Bytes(),
+			MaxCapacity: big.NewInt(15000).Bytes(),
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	connectTestServers(t, a, b)
+	a.SetMaxAnswerSize(GetChannelConfig{}, 8<<10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if _, err := a.GetChannelConfig(ctx, key); !errors.Is(err, ErrAnswerTooLarge) {
+		t.Fatal("should fail with answer too large:", err)
+	}
+
+	a.SetMaxAnswerSize(GetChannelConfig{}, 64<<10)
+	cfg, err := a.GetChannelConfig(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cfg.ExcessFee, svc.cfg.ExcessFee) {
+		t.Fatal("incorrect config")
+	}
+
+	a.SetMaxAnswerSize(GetChannelConfig{}, 0)
+	if size := a.maxAnswerSize(GetChannelConfig{}); size != _RLDPMaxAnswerSize {
+		t.Fatal("default size is not restored", size)
+	}
+
+	// other queries still use default
+	if size := a.maxAnswerSize(GetFeeSchedule{}); size != _RLDPMaxAnswerSize {
+		t.Fatal("incorrect default size", size)
+	}
+}
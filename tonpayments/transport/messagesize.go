@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/rldp"
+	"sync/atomic"
+)
+
+const _DefaultMaxMessageSize = _RLDPMaxAnswerSize
+
+// ErrMessageTooLarge - party declared transfer larger than we accept
+var ErrMessageTooLarge = errors.New("message is too large")
+
+// SetMaxMessageSize - limits declared size of rldp transfers which party can send to us,
+// transfers above it are dropped before rldp allocates decoder for them.
+// Answers for our queries are allowed up to sizes set with SetMaxAnswerSize, even when they are larger.
+// Zero or negative size restores default.
+func (s *Server) SetMaxMessageSize(size int64) {
+	if size <= 0 {
+		size = _DefaultMaxMessageSize
+	}
+
+	s.mx.Lock()
+	s.maxMessageSize = size
+	s.updateMessageSizeLimit()
+	s.mx.Unlock()
+}
+
+// updateMessageSizeLimit - recalculates max declared transfer size we accept from peer,
+// must be called under lock after message or answer size limits are changed
+func (s *Server) updateMessageSizeLimit() {
+	limit := s.maxMessageSize
+	for _, size := range s.maxAnswerSizes {
+		if size > limit {
+			limit = size
+		}
+	}
+	atomic.StoreInt64(&s.messageLimit, limit)
+}
+
+// messageSizeLimit - returns max declared transfer size we accept from peer, called for every packet
+func (s *Server) messageSizeLimit() int64 {
+	return atomic.LoadInt64(&s.messageLimit)
+}
+
+// sizeGuardADNL - peer connection wrapper which checks declared size of incoming rldp transfers.
+// Handlers set by rldp are installed on connection only by start, when peer setup is complete,
+// so messages are not processed while rldp callbacks are still being assigned.
+type sizeGuardADNL struct {
+	rldp.ADNL
+	limit func() int64
+
+	onMessage    func(msg *adnl.MessageCustom) error
+	onDisconnect func(addr string, key ed25519.PublicKey)
+}
+
+// start - installs handlers on connection
+func (g *sizeGuardADNL) start() {
+	g.ADNL.SetCustomMessageHandler(g.onMessage)
+	g.ADNL.SetDisconnectHandler(g.onDisconnect)
+}
+
+func (g *sizeGuardADNL) SetDisconnectHandler(handler func(addr string, key ed25519.PublicKey)) {
+	g.onDisconnect = handler
+}
+
+func (g *sizeGuardADNL) SetCustomMessageHandler(handler func(msg *adnl.MessageCustom) error) {
+	g.onMessage = func(msg *adnl.MessageCustom) error {
+		var size int64
+		var fecType any
+		switch m := msg.Data.(type) {
+		case rldp.MessagePart:
+			size, fecType = m.TotalSize, m.FecType
+		case rldp.MessagePartV2:
+			size, fecType = m.TotalSize, m.FecType
+		default:
+			return handler(msg)
+		}
+
+		limit := g.limit()
+		if size > limit {
+			return fmt.Errorf("%w: declared %d bytes, limit is %d", ErrMessageTooLarge, size, limit)
+		}
+
+		// rldp allocates decoder by fec data size, so it must be checked too
+		if fec, ok := fecType.(rldp.FECRaptorQ); ok {
+			if int64(fec.DataSize) > limit {
+				return fmt.Errorf("%w: fec data size %d bytes, limit is %d", ErrMessageTooLarge, fec.DataSize, limit)
+			}
+			if int64(fec.DataSize) != size {
+				return fmt.Errorf("%w: fec data size %d differs from declared %d", ErrMessageTooLarge, fec.DataSize, size)
+			}
+		}
+		return handler(msg)
+	}
+}
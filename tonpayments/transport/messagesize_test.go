@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/rldp"
+	"testing"
+)
+
+type handlerADNL struct {
+	rldp.ADNL
+	handler func(msg *adnl.MessageCustom) error
+}
+
+func (h *handlerADNL) SetCustomMessageHandler(handler func(msg *adnl.MessageCustom) error) {
+	h.handler = handler
+}
+
+func (h *handlerADNL) SetDisconnectHandler(handler func(addr string, key ed25519.PublicKey)) {}
+
+func TestSizeGuardADNL(t *testing.T) {
+	s := newTestServer(t, &testService{})
+	s.SetMaxMessageSize(4096)
+
+	inner := &handlerADNL{}
+	guard := &sizeGuardADNL{ADNL: inner, limit: s.messageSizeLimit}
+
+	passed := 0
+	guard.SetCustomMessageHandler(func(msg *adnl.MessageCustom) error {
+		passed++
+		return nil
+	})
+	guard.start()
+
+	part := func(size int64) *adnl.MessageCustom {
+		return &adnl.MessageCustom{Data: rldp.MessagePartV2{
+			TransferID: make([]byte, 32),
+			FecType:    rldp.FECRaptorQ{DataSize: int32(size), SymbolSize: 768, SymbolsCount: 1},
+			TotalSize:  size,
+		}}
+	}
+
+	if err := inner.handler(part(1 << 36)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatal("over-limit transfer should be rejected:", err)
+	}
+	if passed != 0 {
+		t.Fatal("over-limit transfer reached rldp")
+	}
+
+	// small declared size cannot hide large decoder allocation
+	lying := &adnl.MessageCustom{Data: rldp.MessagePartV2{
+		TransferID: make([]byte, 32),
+		FecType:    rldp.FECRaptorQ{DataSize: 1 << 30, SymbolSize: 768, SymbolsCount: 1},
+		TotalSize:  1024,
+	}}
+	if err := inner.handler(lying); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatal("over-limit fec data size should be rejected:", err)
+	}
+
+	mismatch := &adnl.MessageCustom{Data: rldp.MessagePart{
+		TransferID: make([]byte, 32),
+		FecType:    rldp.FECRaptorQ{DataSize: 2048, SymbolSize: 768, SymbolsCount: 3},
+		TotalSize:  1024,
+	}}
+	if err := inner.handler(mismatch); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatal("fec data size different from declared should be rejected:", err)
+	}
+	if passed != 0 {
+		t.Fatal("inconsistent transfer reached rldp")
+	}
+
+	if err := inner.handler(part(4096)); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.handler(&adnl.MessageCustom{Data: rldp.Complete{TransferID: make([]byte, 32)}}); err != nil {
+		t.Fatal(err)
+	}
+	if passed != 2 {
+		t.Fatal("messages within limit should be passed to rldp", passed)
+	}
+
+	// answers we allowed to be larger are accepted too
+	s.SetMaxAnswerSize(GetChannelConfig{}, 8192)
+	if err := inner.handler(part(8192)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Metrics - hook to collect server statistics, for example to export them to prometheus.
+// Methods are called concurrently and should not block.
+type Metrics interface {
+	// ObserveQuery - called when our query to peer is finished, err is nil on success
+	ObserveQuery(kind string, d time.Duration, err error)
+	// ObserveHandle - called when query from peer is processed, err is nil on success
+	ObserveHandle(kind string, d time.Duration, err error)
+	// IncPeer - called with 1 when peer is connected and with -1 when disconnected
+	IncPeer(delta int)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveQuery(string, time.Duration, error)  {}
+func (noopMetrics) ObserveHandle(string, time.Duration, error) {}
+func (noopMetrics) IncPeer(int)                                {}
+
+// SetMetrics - sets statistics hook, nil disables it
+func (s *Server) SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+
+	s.mx.Lock()
+	s.metrics = m
+	s.mx.Unlock()
+}
+
+func (s *Server) getMetrics() Metrics {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.metrics
+}
+
+// queryKind - name of the query type, like ProposeAction
+func queryKind(q any) string {
+	if q == nil {
+		return "unknown"
+	}
+	if sq, ok := q.(serializedQuery); ok {
+		return sq.kind
+	}
+	return reflect.TypeOf(q).Name()
+}
+
+// QueryCounters - number of succeeded and failed queries of one type
+type QueryCounters struct {
+	Success uint64
+	Failure uint64
+}
+
+// QueryStats - snapshot of query counters by query type, like ProposeAction
+type QueryStats struct {
+	// Outbound - our queries to peers
+	Outbound map[string]QueryCounters
+	// Inbound - queries from peers processed by us
+	Inbound map[string]QueryCounters
+}
+
+type queryStats struct {
+	outbound map[string]QueryCounters
+	inbound  map[string]QueryCounters
+	mx       sync.Mutex
+}
+
+func (q *queryStats) add(inbound bool, kind string, err error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	list := &q.outbound
+	if inbound {
+		list = &q.inbound
+	}
+	if *list == nil {
+		*list = map[string]QueryCounters{}
+	}
+
+	c := (*list)[kind]
+	if err == nil {
+		c.Success++
+	} else {
+		c.Failure++
+	}
+	(*list)[kind] = c
+}
+
+// QueryStats - returns copy of query counters collected since server start
+func (s *Server) QueryStats() QueryStats {
+	s.stats.mx.Lock()
+	defer s.stats.mx.Unlock()
+
+	res := QueryStats{
+		Outbound: make(map[string]QueryCounters, len(s.stats.outbound)),
+		Inbound:  make(map[string]QueryCounters, len(s.stats.inbound)),
+	}
+	for k, v := range s.stats.outbound {
+		res.Outbound[k] = v
+	}
+	for k, v := range s.stats.inbound {
+		res.Inbound[k] = v
+	}
+	return res
+}
+
+func (s *Server) observeQuery(kind string, d time.Duration, err error) {
+	s.stats.add(false, kind, err)
+	s.getMetrics().ObserveQuery(kind, d, err)
+}
+
+func (s *Server) observeHandle(kind string, d time.Duration, err error) {
+	s.stats.add(true, kind, err)
+	s.getMetrics().ObserveHandle(kind, d, err)
+}
@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	queries []string
+	handled []string
+	peers   int
+
+	mx sync.Mutex
+}
+
+func (m *testMetrics) ObserveQuery(kind string, d time.Duration, err error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if err == nil {
+		m.queries = append(m.queries, kind)
+	}
+}
+
+func (m *testMetrics) ObserveHandle(kind string, d time.Duration, err error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if err == nil {
+		m.handled = append(m.handled, kind)
+	}
+}
+
+func (m *testMetrics) IncPeer(delta int) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.peers += delta
+}
+
+func (m *testMetrics) snapshot() ([]string, []string, int) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]string{}, m.queries...), append([]string{}, m.handled...), m.peers
+}
+
+func TestServer_Metrics(t *testing.T) {
+	ma, mb := &testMetrics{}, &testMetrics{}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	a.SetMetrics(ma)
+	b.SetMetrics(mb)
+
+	peer := connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := a.GetChannelConfig(ctx, b.channelKey.Public().(ed25519.PublicKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	queries, _, peers := ma.snapshot()
+	if len(queries) != 2 || queries[0] != "Authenticate" || queries[1] != "GetChannelConfig" {
+		t.Fatal("incorrect queries", queries)
+	}
+	if peers != 1 {
+		t.Fatal("incorrect peers", peers)
+	}
+
+	// answer is sent asynchronously, so handler can finish after we receive it
+	deadline := time.Now().Add(3 * time.Second)
+	_, handled, _ := mb.snapshot()
+	for len(handled) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		_, handled, _ = mb.snapshot()
+	}
+	if len(handled) != 2 || handled[0] != "Authenticate" || handled[1] != "GetChannelConfig" {
+		t.Fatal("incorrect handled queries", handled)
+	}
+
+	peer.adnl.Close()
+	if _, _, peers = ma.snapshot(); peers != 0 {
+		t.Fatal("disconnect is not reported", peers)
+	}
+}
+
+func TestServer_QueryStats(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	a.SetTimeouts(Timeouts{QueryTimeout: 300 * time.Millisecond, DropPeerAfter: time.Minute})
+	a.SetQueryRetries(0)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	for i := 0; i < 2; i++ {
+		if _, err := a.GetChannelConfig(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// state cannot be parsed, so peer will not answer
+	if _, err := a.ProposeAction(context.Background(), address.NewAddress(0, 0, make([]byte, 32)), key,
+		cell.BeginCell().EndCell(), IncrementStatesAction{}); err == nil {
+		t.Fatal("should fail")
+	}
+
+	stats := a.QueryStats()
+	if c := stats.Outbound["Authenticate"]; c.Success != 1 || c.Failure != 0 {
+		t.Fatal("incorrect auth counters", c)
+	}
+	if c := stats.Outbound["GetChannelConfig"]; c.Success != 2 || c.Failure != 0 {
+		t.Fatal("incorrect config counters", c)
+	}
+	if c := stats.Outbound["ProposeAction"]; c.Success != 0 || c.Failure != 1 {
+		t.Fatal("incorrect propose counters", c)
+	}
+
+	stats = b.QueryStats()
+	if c := stats.Inbound["GetChannelConfig"]; c.Success != 2 {
+		t.Fatal("incorrect inbound config counters", c)
+	}
+	if c := stats.Inbound["ProposeAction"]; c.Failure != 1 {
+		t.Fatal("incorrect inbound propose counters", c)
+	}
+
+	// snapshot is a copy
+	stats.Inbound["GetChannelConfig"] = QueryCounters{}
+	if b.QueryStats().Inbound["GetChannelConfig"].Success != 2 {
+		t.Fatal("internal state was mutated")
+	}
+}
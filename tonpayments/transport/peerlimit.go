@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"errors"
+	"github.com/rs/zerolog/log"
+	"sync/atomic"
+)
+
+// ErrPeerEvicted - connection was closed to accept the new one, because peers limit is reached
+var ErrPeerEvicted = errors.New("evicted because of peers limit")
+
+// SetMaxPeers - limits number of tracked peer connections, when limit is reached,
+// least recently active connection is closed to accept the new one.
+// Not authenticated connections are evicted first. Zero means no limit.
+func (s *Server) SetMaxPeers(num int) {
+	s.mx.Lock()
+	s.maxPeers = num
+	s.mx.Unlock()
+}
+
+// PeersCount - returns number of connected peers, including not authenticated
+func (s *Server) PeersCount() int {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return len(s.peers)
+}
+
+// evictionVictim - selects connection to close when peers limit is reached,
+// least recently active not authenticated peer, or authenticated when there are no others.
+// Must be called under lock.
+func (s *Server) evictionVictim() *PeerConnection {
+	if s.maxPeers <= 0 || len(s.peers) < s.maxPeers {
+		return nil
+	}
+
+	var victim *PeerConnection
+	for _, p := range s.peers {
+		if victim == nil {
+			victim = p
+			continue
+		}
+
+		if (p.authKey == nil) != (victim.authKey == nil) {
+			if p.authKey == nil {
+				victim = p
+			}
+			continue
+		}
+
+		if atomic.LoadInt64(&p.lastActivity) < atomic.LoadInt64(&victim.lastActivity) {
+			victim = p
+		}
+	}
+
+	if victim != nil {
+		s.peerLog(log.Info().Str("source", "server"), victim.authKey).Str("addr", victim.adnl.RemoteAddr()).
+			Int("limit", s.maxPeers).Msg("peers limit reached, evicting connection")
+	}
+	return victim
+}
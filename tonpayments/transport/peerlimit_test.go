@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_MaxPeers(t *testing.T) {
+	var evicted []string
+	var mx sync.Mutex
+	a := newTestServer(t, &testService{}, func(s *Server) {
+		s.SetOnConnectionRejected(func(reason error, addr string) {
+			if errors.Is(reason, ErrPeerEvicted) {
+				mx.Lock()
+				evicted = append(evicted, addr)
+				mx.Unlock()
+			}
+		})
+	})
+	a.SetMaxPeers(2)
+
+	b := newTestServer(t, &testService{})
+	connectTestServers(t, b, a)
+
+	// not authenticated peers, they are only pinging us
+	ping := func(from *Server) {
+		client, err := from.gate.RegisterClient(testAddr(a), a.key.Public().(ed25519.PublicKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = from.ping(from.bootstrapPeer(client), 3*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newTestServer(t, &testService{})
+	ping(c)
+	if n := a.PeersCount(); n != 2 {
+		t.Fatal("incorrect peers count", n)
+	}
+	if hasB, hasC := peersContain(a.ListPeers(), b, c); !hasB || !hasC {
+		t.Fatal("peers are not tracked")
+	}
+
+	// limit is reached, not authenticated c should be evicted instead of authenticated b
+	d := newTestServer(t, &testService{})
+	ping(d)
+
+	// eviction goes through disconnect handler
+	time.Sleep(100 * time.Millisecond)
+
+	peers := a.ListPeers()
+	if len(peers) != 2 || a.PeersCount() != 2 {
+		t.Fatal("incorrect peers count", len(peers))
+	}
+
+	hasB, hasC := peersContain(peers, b, c)
+	if !hasB {
+		t.Fatal("authenticated peer is evicted")
+	}
+	if hasC {
+		t.Fatal("not authenticated peer is not evicted")
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(evicted) != 1 || evicted[0] != testAddr(c) {
+		t.Fatal("eviction is not reported", evicted)
+	}
+}
+
+func peersContain(peers []PeerInfo, auth, anon *Server) (hasAuth, hasAnon bool) {
+	for _, p := range peers {
+		if bytes.Equal(p.AuthKey, auth.channelKey.Public().(ed25519.PublicKey)) {
+			hasAuth = true
+		}
+		if bytes.Equal(p.ADNLID, anon.gate.GetID()) {
+			hasAnon = true
+		}
+	}
+	return hasAuth, hasAnon
+}
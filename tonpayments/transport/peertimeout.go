@@ -0,0 +1,45 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"time"
+)
+
+// SetPeerTimeout - overrides query timeout for the party with channelKey, for slow parties.
+// Handlers of queries from this party get at least the same time budget for processing,
+// so timeouts are consistent in both directions. Zero timeout removes override.
+func (s *Server) SetPeerTimeout(channelKey ed25519.PublicKey, timeout time.Duration) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if timeout <= 0 {
+		delete(s.peerTimeouts, string(channelKey))
+		return
+	}
+	s.peerTimeouts[string(channelKey)] = timeout
+}
+
+// queryTimeout - returns timeout for outgoing query to the party
+func (s *Server) queryTimeout(channelKey []byte) time.Duration {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	if t, ok := s.peerTimeouts[string(channelKey)]; ok {
+		return t
+	}
+	return s.timeouts.QueryTimeout
+}
+
+// handleTimeout - returns time budget for processing of query from peer
+func (s *Server) handleTimeout(peer *PeerConnection) time.Duration {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	timeout := s.timeouts.HandleTimeout
+	if peer.authKey != nil {
+		if t, ok := s.peerTimeouts[string(peer.authKey)]; ok && t > timeout {
+			timeout = t
+		}
+	}
+	return timeout
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/xssnick/ton-payment-network/pkg/payments"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"testing"
+	"time"
+)
+
+func TestServer_PeerTimeout(t *testing.T) {
+	budgets := make(chan time.Duration, 1)
+	svc := &testService{
+		processAction: func(ctx context.Context, key ed25519.PublicKey, channelAddr *address.Address, signedState payments.SignedSemiChannel, action Action) (*payments.SignedSemiChannel, error) {
+			dl, _ := ctx.Deadline()
+			budgets <- time.Until(dl)
+			return &signedState, nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	b.SetTimeouts(Timeouts{HandleTimeout: time.Second})
+	connectTestServers(t, a, b)
+
+	aKey := a.channelKey.Public().(ed25519.PublicKey)
+	bKey := b.channelKey.Public().(ed25519.PublicKey)
+
+	propose := func(seqno uint64) time.Duration {
+		st, err := tlb.ToCell(testSignedState(seqno))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = a.ProposeAction(context.Background(), address.NewAddress(0, 0, make([]byte, 32)), bKey, st, IncrementStatesAction{}); err != nil {
+			t.Fatal(err)
+		}
+		return <-budgets
+	}
+
+	if budget := propose(1); budget > time.Second {
+		t.Fatal("default handle timeout is not applied", budget)
+	}
+
+	// slow party gets the same budget in both directions
+	a.SetPeerTimeout(bKey, 30*time.Second)
+	b.SetPeerTimeout(aKey, 30*time.Second)
+	if d := a.queryTimeout(bKey); d != 30*time.Second {
+		t.Fatal("peer query timeout is not applied", d)
+	}
+	if budget := propose(2); budget < 29*time.Second {
+		t.Fatal("peer timeout is not propagated to handler", budget)
+	}
+
+	b.SetPeerTimeout(aKey, 0)
+	if budget := propose(3); budget > time.Second {
+		t.Fatal("peer timeout is not removed", budget)
+	}
+}
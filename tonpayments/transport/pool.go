@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrBusy - inbound queries queue is full, query is rejected without processing
+var ErrBusy = errors.New("server is busy")
+
+const _DefaultQueryWorkers = 32
+const _DefaultQueryQueueSize = 256
+
+// workerPool - executes submitted jobs using fixed number of goroutines,
+// jobs that cannot fit into the queue are rejected instead of blocking caller
+type workerPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+}
+
+func newWorkerPool(workers, queueSize int) *workerPool {
+	if workers <= 0 {
+		workers = _DefaultQueryWorkers
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &workerPool{
+		jobs: make(chan func(), queueSize),
+	}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *workerPool) worker() {
+	defer p.wg.Done()
+
+	for job := range p.jobs {
+		job()
+	}
+}
+
+// Submit - puts job to the queue, returns false when queue is full
+func (p *workerPool) Submit(job func()) bool {
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop - stops accepting new jobs, already queued jobs will be processed.
+// Submit must not be called after Stop.
+func (p *workerPool) Stop() {
+	close(p.jobs)
+}
@@ -0,0 +1,213 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tl"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_BoundedConcurrency(t *testing.T) {
+	const workers, queue = 3, 5
+
+	p := newWorkerPool(workers, queue)
+	defer p.Stop()
+
+	var active, maxActive int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+
+	job := func() {
+		defer wg.Done()
+
+		n := atomic.AddInt32(&active, 1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&active, -1)
+	}
+
+	accepted, rejected := 0, 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		if !p.Submit(job) {
+			wg.Done()
+			rejected++
+			continue
+		}
+		accepted++
+
+		if i < workers {
+			// let worker pick job, to have stable queue capacity
+			for atomic.LoadInt32(&active) != int32(i+1) {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	if accepted != workers+queue {
+		t.Fatal("unexpected accepted jobs num", accepted)
+	}
+	if rejected != 20-workers-queue {
+		t.Fatal("unexpected rejected jobs num", rejected)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if maxActive > workers {
+		t.Fatal("concurrency is not bounded, max active:", maxActive)
+	}
+}
+
+func TestServer_BusyDecision(t *testing.T) {
+	var calls atomic.Int32
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			calls.Add(1)
+			entered <- struct{}{}
+			<-release
+			return nil
+		},
+	})
+	b.SetQueryWorkers(1, 1)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request := func() error {
+		_, err := a.RequestInboundChannel(ctx, big.NewInt(1000), nil, address.NewAddress(0, 0, make([]byte, 32)),
+			a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+		return err
+	}
+
+	results := make(chan error, 2)
+	go func() {
+		results <- request()
+	}()
+	<-entered
+
+	go func() {
+		results <- request()
+	}()
+	for len(b.queryPool.jobs) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the only worker is busy and the queue is full
+	var retryErr *RetryableError
+	if err := request(); !errors.As(err, &retryErr) || !errors.Is(err, ErrBusy) {
+		t.Fatal("should be rejected as busy:", err)
+	}
+	if retryErr.RetryAfter != _RejectionRetryAfter*time.Second {
+		t.Fatal("retry hint is not passed:", retryErr.RetryAfter)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Fatal("rejected query reached service")
+	}
+}
+
+func TestServer_QueuedQueryExpires(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	a := newTestServer(t, &testService{})
+	a.SetQueryRetries(0)
+	b := newTestServer(t, &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			if calls.Add(1) == 1 {
+				<-release
+			}
+			return nil
+		},
+	})
+	b.SetQueryWorkers(1, 1)
+	connectTestServers(t, a, b)
+	b.SetTimeouts(Timeouts{HandleTimeout: 200 * time.Millisecond})
+
+	request := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, _ = a.RequestInboundChannel(ctx, big.NewInt(1000), nil, address.NewAddress(0, 0, make([]byte, 32)),
+			a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		request()
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		defer wg.Done()
+		request()
+	}()
+
+	// second query waits in queue longer than handle timeout
+	time.Sleep(400 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatal("expired query is processed", n)
+	}
+}
+
+func TestRejectionAnswer_Busy(t *testing.T) {
+	for _, query := range []any{RequestAction{}, ConfirmClose{}, ProposeAction{}, ProposeActions{Actions: make([]ProposeAction, 2)}} {
+		data, err := tl.Serialize(rejectionAnswer(query, ErrBusy), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var errs []error
+		var res tl.Serializable
+		if _, err = tl.Parse(&res, data, true); err != nil {
+			t.Fatal(err)
+		}
+		switch r := res.(type) {
+		case Decision:
+			errs = append(errs, r.rejection())
+		case ProposalDecision:
+			errs = append(errs, r.rejection())
+		case ProposalDecisions:
+			for _, d := range r.List {
+				errs = append(errs, d.rejection())
+			}
+		default:
+			t.Fatalf("unexpected answer %T", res)
+		}
+
+		for _, err = range errs {
+			var retryErr *RetryableError
+			if !errors.As(err, &retryErr) || !errors.Is(err, ErrBusy) || retryErr.RetryAfter != _RejectionRetryAfter*time.Second {
+				t.Fatalf("%T rejection is not retryable: %v", query, err)
+			}
+		}
+	}
+}
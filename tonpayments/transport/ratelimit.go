@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited - peer sends queries faster than allowed
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimit - token bucket parameters, Rate is number of queries per second,
+// Burst is how many queries can be sent at once. Zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	mx sync.Mutex
+}
+
+func newTokenBucket(l RateLimit) *tokenBucket {
+	if l.Rate <= 0 {
+		return nil
+	}
+	if l.Burst <= 0 {
+		l.Burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   l.Rate,
+		burst:  float64(l.Burst),
+		tokens: float64(l.Burst),
+		last:   time.Now(),
+	}
+}
+
+// allow - takes token if available, nil bucket allows everything
+func (b *tokenBucket) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// SetRateLimits - limits inbound queries per peer, separately for not authenticated and authenticated peers.
+// Applied to new connections only.
+func (s *Server) SetRateLimits(unauthenticated, authenticated RateLimit) {
+	s.mx.Lock()
+	s.anonLimit = unauthenticated
+	s.authLimit = authenticated
+	s.mx.Unlock()
+}
+
+// _RejectionRetryAfter - retry hint sent with queries rejected because of load, in seconds
+const _RejectionRetryAfter = 1
+
+// RetryableError - query was rejected by party without processing, because party is busy or we are
+// rate limited. Unlike DecisionError it is not a decision on the query, and query can be repeated after RetryAfter.
+// Err is ErrBusy or ErrRateLimited.
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return fmt.Sprintf("rejected by peer without processing, retry after %s: %s", e.RetryAfter, e.Err.Error())
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// retryableRejections - errors of queries rejected because of load, they are answered with retry hint
+var retryableRejections = []error{ErrBusy, ErrRateLimited}
+
+// retryableRejection - returns RetryableError when reason of rejection with retry hint is our load error, nil otherwise
+func retryableRejection(reason string, retryAfter int64) error {
+	for _, err := range retryableRejections {
+		if reason == err.Error() {
+			return &RetryableError{Err: err, RetryAfter: time.Duration(retryAfter) * time.Second}
+		}
+	}
+	return nil
+}
+
+// rejection - converts not agreed decision to error
+func (d *Decision) rejection() error {
+	if d.Flags&2 != 0 {
+		if err := retryableRejection(d.Reason, d.RetryAfter); err != nil {
+			return err
+		}
+	}
+	return &DecisionError{Reason: d.Reason}
+}
+
+// rejection - converts not agreed proposal decision to error
+func (d *ProposalDecision) rejection() error {
+	if d.Flags&1 != 0 {
+		if err := retryableRejection(d.Reason, d.RetryAfter); err != nil {
+			return err
+		}
+	}
+	return &DecisionError{Reason: d.Reason}
+}
+
+// rejectionAnswer - rejection to send instead of the answer, when the query type has a decision,
+// nil means that query should be dropped without answer. Rejections because of load are marked
+// with retry hint, so party can tell them from the decision on the query.
+func rejectionAnswer(query any, reject error) any {
+	dec := Decision{Agreed: false, Reason: reject.Error()}
+	propDec := ProposalDecision{Agreed: false, Reason: reject.Error()}
+	for _, err := range retryableRejections {
+		if errors.Is(reject, err) {
+			dec.Flags, dec.RetryAfter = 2, _RejectionRetryAfter
+			propDec.Flags, propDec.RetryAfter = 1, _RejectionRetryAfter
+			break
+		}
+	}
+
+	switch q := query.(type) {
+	case RequestAction, RequestInboundChannel, NegotiateSettlement, ConfirmClose:
+		return dec
+	case ProposeAction:
+		return propDec
+	case ProposeActions:
+		res := ProposalDecisions{List: make([]ProposalDecision, len(q.Actions))}
+		for i := range res.List {
+			res.List[i] = propDec
+		}
+		return res
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"github.com/xssnick/tonutils-go/address"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimit{Rate: 2, Burst: 3})
+	b.last = now
+
+	for i := 0; i < 3; i++ {
+		if !b.allow(now) {
+			t.Fatal("burst is not allowed", i)
+		}
+	}
+	if b.allow(now) {
+		t.Fatal("limit exceeded")
+	}
+
+	// 2 per second, so 1 token is refilled in 500ms
+	if !b.allow(now.Add(500 * time.Millisecond)) {
+		t.Fatal("token is not refilled")
+	}
+	if b.allow(now.Add(500 * time.Millisecond)) {
+		t.Fatal("limit exceeded after refill")
+	}
+
+	if !newTokenBucket(RateLimit{}).allow(now) {
+		t.Fatal("zero rate should not limit")
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	var calls atomic.Int32
+	svc := &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			calls.Add(1)
+			return nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	// auth query is counted as unauthenticated one
+	b.SetRateLimits(RateLimit{Rate: 0.001, Burst: 1}, RateLimit{Rate: 0.001, Burst: 2})
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request := func() error {
+		_, err := a.RequestInboundChannel(ctx, big.NewInt(1000), nil, address.NewAddress(0, 0, make([]byte, 32)),
+			a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := request(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var retryErr *RetryableError
+	if err := request(); !errors.As(err, &retryErr) || !errors.Is(err, ErrRateLimited) {
+		t.Fatal("query is not rate limited:", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatal("rate limited query reached service")
+	}
+	if c := b.QueryStats().Inbound["RequestInboundChannel"]; c.Failure != 1 {
+		t.Fatal("dropped query is not counted", c)
+	}
+}
+
+func TestRejectionAnswer_RateLimited(t *testing.T) {
+	dec := rejectionAnswer(ProposeAction{}, ErrRateLimited).(ProposalDecision)
+
+	var retryErr *RetryableError
+	if err := dec.rejection(); !errors.As(err, &retryErr) || !errors.Is(err, ErrRateLimited) {
+		t.Fatal("rate limited rejection is not retryable:", err)
+	}
+
+	// same reason without retry hint is a decision of the party
+	dec.Flags = 0
+	var decErr *DecisionError
+	if err := dec.rejection(); !errors.As(err, &decErr) {
+		t.Fatal("rejection without hint should be a decision:", err)
+	}
+}
@@ -0,0 +1,35 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"github.com/xssnick/tonutils-go/tl"
+)
+
+// serializedQuery - query serialized once, to send the same bytes to many peers without serializing it again
+type serializedQuery struct {
+	kind string
+	data tl.Raw
+}
+
+// serializeQuery - prepares query for doQueryRaw
+func serializeQuery(req tl.Serializable) (serializedQuery, error) {
+	data, err := tl.Serialize(req, true)
+	if err != nil {
+		return serializedQuery{}, fmt.Errorf("%w: failed to serialize query: %s", ErrInternal, err.Error())
+	}
+	return serializedQuery{kind: queryKind(req), data: data}, nil
+}
+
+// doQueryRaw - same as doQuery, but with already serialized request, used for broadcasts
+func (s *Server) doQueryRaw(ctx context.Context, theirKey []byte, req serializedQuery, resp tl.Serializable) error {
+	return s.doQuery(ctx, theirKey, req, resp)
+}
+
+// wireQuery - returns what should be passed to rldp, serialized queries are sent as is
+func wireQuery(req tl.Serializable) tl.Serializable {
+	if q, ok := req.(serializedQuery); ok {
+		return q.data
+	}
+	return req
+}
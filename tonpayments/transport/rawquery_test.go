@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"github.com/xssnick/tonutils-go/tl"
+	"testing"
+	"time"
+)
+
+func TestServer_DoQueryRaw(t *testing.T) {
+	a := newTestServer(t, &testService{})
+
+	var keys [][]byte
+	for i := 0; i < 3; i++ {
+		b := newTestServer(t, &testService{})
+		connectTestServers(t, a, b)
+		keys = append(keys, b.channelKey.Public().(ed25519.PublicKey))
+	}
+
+	// serialized once, only bytes are kept, so there is nothing to serialize again
+	req, err := serializeQuery(Ping{Timestamp: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.kind != "Ping" {
+		t.Fatal("incorrect kind", req.kind)
+	}
+
+	// patch timestamp in serialized bytes, peers answer with it only if exactly these bytes are sent,
+	// and not the query serialized again from the struct
+	binary.LittleEndian.PutUint64(req.data[4:], 9)
+	sent := append(tl.Raw{}, req.data...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before := a.QueryStats().Outbound["Ping"]
+
+	// broadcast, the same bytes are sent to all peers
+	for _, key := range keys {
+		var res Pong
+		if err = a.doQueryRaw(ctx, key, req, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Timestamp != 9 {
+			t.Fatal("serialized bytes are not sent as is, answer", res.Timestamp)
+		}
+	}
+
+	if !bytes.Equal(req.data, sent) {
+		t.Fatal("serialized query was modified by send")
+	}
+
+	if c := a.QueryStats().Outbound["Ping"]; c.Success-before.Success != 3 {
+		t.Fatal("raw queries are not counted by their type", c)
+	}
+}
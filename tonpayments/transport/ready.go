@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"time"
+)
+
+// Ready - checks that server can serve parties: it is not closed, service is set, and in server mode,
+// gateway has addresses and our dht record is stored and not expired. Reason is filled when not ready.
+func (s *Server) Ready() (bool, string) {
+	s.mx.RLock()
+	closed := s.closed
+	svc := s.svc
+	s.mx.RUnlock()
+
+	if closed {
+		return false, "server is closed"
+	}
+	if svc == nil {
+		return false, "service is not set"
+	}
+
+	if !s.serverMode {
+		// we are not discoverable by design, dht is used only to resolve others
+		return true, ""
+	}
+
+	if len(s.gate.GetAddressList().Addresses) == 0 {
+		return false, errNoAddresses.Error()
+	}
+
+	lastStored := s.dhtStale.lastStoredAt()
+	if lastStored.IsZero() {
+		return false, "dht record is not stored yet"
+	}
+	if time.Since(lastStored) > _DHTRecordTTL {
+		return false, "dht record is expired"
+	}
+	return true, ""
+}
@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"github.com/xssnick/tonutils-go/adnl"
+	"testing"
+	"time"
+)
+
+func TestServer_Ready(t *testing.T) {
+	check := func(s *Server, ready bool, reason string) {
+		t.Helper()
+		ok, why := s.Ready()
+		if ok != ready || why != reason {
+			t.Fatal("incorrect readiness", ok, why)
+		}
+	}
+
+	// client mode, dht record is not needed
+	s := newTestServer(t, &testService{})
+	check(s, true, "")
+
+	s.SetService(nil)
+	check(s, false, "service is not set")
+	s.SetService(&testService{})
+
+	s.serverMode = true
+	check(s, false, "dht record is not stored yet")
+
+	s.dhtStale.observe(time.Now().Add(-_DHTRecordTTL-time.Second), nil)
+	check(s, false, "dht record is expired")
+
+	s.dhtStale.observe(time.Now(), nil)
+	check(s, true, "")
+
+	_ = s.Close()
+	check(s, false, "server is closed")
+
+	// gateway is not started, so it has no addresses to publish
+	_, key, _ := ed25519.GenerateKey(nil)
+	_, channelKey, _ := ed25519.GenerateKey(nil)
+	notStarted := NewServer(nil, adnl.NewGateway(key), key, channelKey, false, DHTBackoff{})
+	notStarted.SetService(&testService{})
+	notStarted.serverMode = true
+	check(notStarted, false, "gateway has no addresses")
+}
@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"fmt"
+	"github.com/xssnick/tonutils-go/tl"
+	"sync"
+	"time"
+)
+
+type cachedResponse struct {
+	answer  tl.Serializable
+	expires time.Time
+}
+
+// responseCache - answers for read queries which are rarely changed, by query kind
+type responseCache struct {
+	ttls    map[string]time.Duration
+	entries map[string]cachedResponse
+	mx      sync.Mutex
+}
+
+// SetResponseCacheTTL - enables caching of answers for read query, like GetChannelConfig{},
+// so service is not called for each query. Zero ttl disables caching for this query type.
+func (s *Server) SetResponseCacheTTL(query tl.Serializable, ttl time.Duration) error {
+	switch query.(type) {
+	case GetChannelConfig, GetFeeSchedule:
+	default:
+		return fmt.Errorf("caching is not supported for %T", query)
+	}
+
+	kind := queryKind(query)
+
+	c := &s.respCache
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.ttls == nil {
+		c.ttls = map[string]time.Duration{}
+		c.entries = map[string]cachedResponse{}
+	}
+	c.ttls[kind] = ttl
+	delete(c.entries, kind)
+	return nil
+}
+
+// get - returns cached answer for query kind, or calls getter and caches its result when caching is enabled
+func (c *responseCache) get(kind string, getter func() tl.Serializable) tl.Serializable {
+	c.mx.Lock()
+	ttl := c.ttls[kind]
+	if ttl <= 0 {
+		c.mx.Unlock()
+		return getter()
+	}
+
+	if e, ok := c.entries[kind]; ok && time.Now().Before(e.expires) {
+		c.mx.Unlock()
+		return e.answer
+	}
+	c.mx.Unlock()
+
+	answer := getter()
+
+	c.mx.Lock()
+	c.entries[kind] = cachedResponse{
+		answer:  answer,
+		expires: time.Now().Add(ttl),
+	}
+	c.mx.Unlock()
+	return answer
+}
@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingService struct {
+	*testService
+	configCalls int32
+}
+
+func (c *countingService) GetChannelConfig() ChannelConfig {
+	atomic.AddInt32(&c.configCalls, 1)
+	return c.testService.GetChannelConfig()
+}
+
+func TestServer_ResponseCache(t *testing.T) {
+	svc := &countingService{testService: &testService{
+		cfg: ChannelConfig{
+			ExcessFee:   big.NewInt(1000).Bytes(),
+			WalletAddr:  make([]byte, 32),
+			MinCapacity: big.NewInt(100).Bytes(),
+			MaxCapacity: big.NewInt(15000).Bytes(),
+		},
+	}}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	if err := b.SetResponseCacheTTL(GetChannelConfig{}, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	for i := 0; i < 2; i++ {
+		cfg, err := a.GetChannelConfig(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if new(big.Int).SetBytes(cfg.MaxCapacity).Int64() != 15000 {
+			t.Fatal("incorrect max capacity")
+		}
+	}
+	if n := atomic.LoadInt32(&svc.configCalls); n != 1 {
+		t.Fatal("second read is not served from cache, service calls:", n)
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	if _, err := a.GetChannelConfig(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&svc.configCalls); n != 2 {
+		t.Fatal("expired answer served from cache, service calls:", n)
+	}
+}
+
+func TestServer_ResponseCacheUnsupported(t *testing.T) {
+	s := newTestServer(t, &testService{})
+	if err := s.SetResponseCacheTTL(GetSharedChannels{}, time.Minute); err == nil {
+		t.Fatal("caching of peer specific query accepted")
+	}
+}
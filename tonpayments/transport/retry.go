@@ -0,0 +1,16 @@
+package transport
+
+const _DefaultQueryRetries = 1
+
+// SetQueryRetries - sets how many times failed outgoing query is retried, with reconnect
+// and new authentication before each retry. Only network failures are retried,
+// answers with rejection are returned as is. Zero disables retries.
+func (s *Server) SetQueryRetries(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	s.mx.Lock()
+	s.queryRetries = n
+	s.mx.Unlock()
+}
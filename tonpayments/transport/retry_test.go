@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/address"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_QueryRetry(t *testing.T) {
+	var calls int32
+	svc := &testService{
+		sharedChannels: func(ctx context.Context, key ed25519.PublicKey) ([]SharedChannel, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				// first answer is lost
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return []SharedChannel{{Address: make([]byte, 32), Status: 1}}, nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	a.SetTimeouts(Timeouts{QueryTimeout: 300 * time.Millisecond, DropPeerAfter: time.Minute})
+	b.SetTimeouts(Timeouts{HandleTimeout: 500 * time.Millisecond})
+	first := connectTestServers(t, a, b)
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	// to reconnect without dht
+	if err := a.AddStaticPeer(key, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	// without caller's deadline, query timeout is applied to each attempt
+	ctx := context.Background()
+
+	list, err := a.GetSharedChannels(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatal("incorrect answer")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatal("query is not retried, calls:", n)
+	}
+
+	a.mx.RLock()
+	second := a.peersByKey[string(key)]
+	a.mx.RUnlock()
+	if second == nil || second == first {
+		t.Fatal("peer is not reconnected before retry")
+	}
+
+	// no retries, timeout is returned
+	atomic.StoreInt32(&calls, 0)
+	a.SetQueryRetries(0)
+	if _, err = a.GetSharedChannels(ctx, key); !errors.Is(err, ErrQueryTimeout) {
+		t.Fatal("should fail with timeout:", err)
+	}
+}
+
+func TestServer_QueryRetryNotOnRejection(t *testing.T) {
+	var calls int32
+	svc := &testService{
+		processInbound: func(ctx context.Context, capacity *big.Int, jettonMaster, walletAddr *address.Address, key ed25519.PublicKey) error {
+			atomic.AddInt32(&calls, 1)
+			return fmt.Errorf("not enough capacity")
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	a.SetQueryRetries(3)
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := a.RequestInboundChannel(ctx, big.NewInt(1000), nil, address.NewAddress(0, 0, make([]byte, 32)),
+		a.channelKey.Public().(ed25519.PublicKey), b.channelKey.Public().(ed25519.PublicKey))
+
+	var decErr *DecisionError
+	if !errors.As(err, &decErr) {
+		t.Fatal("should be rejected:", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatal("rejected query is retried, calls:", n)
+	}
+}
@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// max number of channels which last accepted seqno is remembered, least recently used is evicted
+const _MaxTrackedSeqnos = 65536
+
+// channelSeqno - last accepted their state seqno of the channel, mx is held while proposal
+// for the channel is checked and processed by service, so concurrent duplicates are serialized
+type channelSeqno struct {
+	mx       sync.Mutex
+	last     uint64
+	accepted bool
+
+	// holders and waiters of mx, entry is not evicted while used, guarded by server lock
+	users    int
+	lastUsed time.Time
+}
+
+// seqnoProposal - state seqno proposed for the channel
+type seqnoProposal struct {
+	channelAddr string
+	seqno       uint64
+}
+
+// acquireSeqnos - locks channels of proposals and rejects them when state is older than the last
+// accepted one, before they reach the service. The same seqno is passed, it is a replay of proposal
+// which answer was lost, and service answers it idempotently. Channels stay locked until release is called,
+// with accepted true when service has accepted the proposals, so their seqnos are remembered.
+func (s *Server) acquireSeqnos(list []seqnoProposal) (release func(accepted bool), err error) {
+	addrs := make([]string, 0, len(list))
+	entries := map[string]*channelSeqno{}
+
+	s.mx.Lock()
+	for _, p := range list {
+		if entries[p.channelAddr] != nil {
+			continue
+		}
+
+		e := s.seqnos[p.channelAddr]
+		if e == nil {
+			s.evictSeqno()
+			e = &channelSeqno{}
+			s.seqnos[p.channelAddr] = e
+		}
+		e.users++
+		e.lastUsed = time.Now()
+
+		entries[p.channelAddr] = e
+		addrs = append(addrs, p.channelAddr)
+	}
+	s.mx.Unlock()
+
+	// stable order, to not deadlock with batch locking the same channels
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		entries[addr].mx.Lock()
+	}
+
+	unlock := func() {
+		for _, addr := range addrs {
+			entries[addr].mx.Unlock()
+		}
+
+		s.mx.Lock()
+		for _, addr := range addrs {
+			e := entries[addr]
+			e.users--
+			// accepted is read only when nobody else holds the entry
+			if e.users == 0 && !e.accepted && s.seqnos[addr] == e {
+				// nothing to remember, so rejected proposals do not fill the map
+				delete(s.seqnos, addr)
+			}
+		}
+		s.mx.Unlock()
+	}
+
+	// proposals for the same channel in one batch should be strictly ordered
+	newest := map[string]uint64{}
+	for _, p := range list {
+		e := entries[p.channelAddr]
+
+		if last, ok := newest[p.channelAddr]; ok {
+			if p.seqno <= last {
+				unlock()
+				return nil, fmt.Errorf("channel %s: state seqno %d is not newer than previous %d in batch", p.channelAddr, p.seqno, last)
+			}
+		} else if e.accepted && p.seqno < e.last {
+			unlock()
+			return nil, fmt.Errorf("channel %s: state seqno %d is older than already accepted %d", p.channelAddr, p.seqno, e.last)
+		}
+		newest[p.channelAddr] = p.seqno
+	}
+
+	return func(accepted bool) {
+		if accepted {
+			for addr, seqno := range newest {
+				entries[addr].last = seqno
+				entries[addr].accepted = true
+			}
+		}
+		unlock()
+	}, nil
+}
+
+// evictSeqno - frees place for a new channel when limit is reached, must be called under lock
+func (s *Server) evictSeqno() {
+	if len(s.seqnos) < _MaxTrackedSeqnos {
+		return
+	}
+
+	var oldest string
+	var oldestAt time.Time
+	for addr, e := range s.seqnos {
+		if e.users > 0 {
+			continue
+		}
+		if oldest == "" || e.lastUsed.Before(oldestAt) {
+			oldest, oldestAt = addr, e.lastUsed
+		}
+	}
+
+	if oldest != "" {
+		delete(s.seqnos, oldest)
+	}
+}
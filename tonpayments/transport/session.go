@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// _SessionMaxAge - session cannot be resumed when its signature is older, even if connection flaps within grace
+const _SessionMaxAge = 30 * time.Minute
+
+// max number of kept sessions, when reached, the one which expires first is forgotten
+const _MaxSessions = 4096
+
+// authSession - last successful authentication of the party, signed by it for both adnl ids,
+// so it stays valid as long as connection is reestablished between the same adnl addresses.
+type authSession struct {
+	adnlID []byte
+	auth   Authenticate
+	// zero while connection is alive, set on disconnect
+	expiresAt time.Time
+}
+
+// SetSessionResumption - when grace > 0, after disconnect of authenticated peer its auth is kept for grace period,
+// and reconnection from the same adnl address is authenticated without handshake round-trip, using kept
+// signature of the party, which is verified again. Must be enabled on both sides, otherwise party will not
+// recognize resumed connection, and its queries will fail until the full handshake. Zero disables it.
+func (s *Server) SetSessionResumption(grace time.Duration) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.sessionGrace = grace
+	if grace <= 0 {
+		s.sessions = map[string]*authSession{}
+		s.sessionsByADNL = map[string]*authSession{}
+	}
+}
+
+// saveSession - remembers auth of the party, signed for its and our adnl ids
+func (s *Server) saveSession(peer *PeerConnection, auth Authenticate) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	peer.resumed = false
+	if s.sessionGrace <= 0 {
+		return
+	}
+
+	id := peer.adnl.GetID()
+	s.deleteSession(auth.Key)
+	if old := s.sessionsByADNL[string(id)]; old != nil {
+		// adnl id is taken by another channel key now, its session cannot be resumed
+		s.deleteSession(old.auth.Key)
+	}
+	if len(s.sessions) >= _MaxSessions {
+		s.evictSession()
+	}
+
+	sess := &authSession{
+		adnlID: id,
+		auth:   auth,
+	}
+	s.sessions[string(auth.Key)] = sess
+	s.sessionsByADNL[string(sess.adnlID)] = sess
+}
+
+// expireSession - starts grace period of the session, must be called under lock
+func (s *Server) expireSession(peer *PeerConnection) {
+	if sess := s.sessions[string(peer.authKey)]; sess != nil && bytes.Equal(sess.adnlID, peer.adnl.GetID()) {
+		sess.expiresAt = time.Now().Add(s.sessionGrace)
+	}
+}
+
+// dropSession - forgets session, so the next connection will do full handshake
+func (s *Server) dropSession(key []byte) {
+	s.mx.Lock()
+	s.deleteSession(key)
+	s.mx.Unlock()
+}
+
+// deleteSession - removes session of the party from both indexes, must be called under lock
+func (s *Server) deleteSession(key []byte) {
+	if sess := s.sessions[string(key)]; sess != nil {
+		delete(s.sessions, string(key))
+		if s.sessionsByADNL[string(sess.adnlID)] == sess {
+			delete(s.sessionsByADNL, string(sess.adnlID))
+		}
+	}
+}
+
+// evictSession - frees place for a new session, expired ones are removed first,
+// otherwise the one which expires soonest, alive sessions are the last. Must be called under lock.
+func (s *Server) evictSession() {
+	now := time.Now()
+
+	var victim *authSession
+	for _, sess := range s.sessions {
+		if !sess.expiresAt.IsZero() && now.After(sess.expiresAt) {
+			s.deleteSession(sess.auth.Key)
+			continue
+		}
+
+		if victim == nil || sessionExpiresBefore(sess, victim) {
+			victim = sess
+		}
+	}
+
+	if len(s.sessions) >= _MaxSessions && victim != nil {
+		s.deleteSession(victim.auth.Key)
+	}
+}
+
+// sessionExpiresBefore - alive sessions have zero expiration, and expire after all disconnected ones
+func sessionExpiresBefore(a, b *authSession) bool {
+	if a.expiresAt.IsZero() != b.expiresAt.IsZero() {
+		return b.expiresAt.IsZero()
+	}
+	if a.expiresAt.IsZero() {
+		return a.auth.Timestamp < b.auth.Timestamp
+	}
+	return a.expiresAt.Before(b.expiresAt)
+}
+
+// resumeSession - authenticates peer using session kept after disconnect, when it is possible,
+// inbound is true when connection is resumed by the party
+func (s *Server) resumeSession(ctx context.Context, peer *PeerConnection, inbound bool) bool {
+	id := peer.adnl.GetID()
+	now := time.Now()
+
+	s.mx.Lock()
+	// session can be alive too, when party reconnected before we noticed disconnect
+	found := s.sessionsByADNL[string(id)]
+	if found != nil && !found.expiresAt.IsZero() && now.After(found.expiresAt) {
+		s.deleteSession(found.auth.Key)
+		found = nil
+	}
+	s.mx.Unlock()
+
+	if found == nil {
+		return false
+	}
+
+	if err := s.verifySession(found, id, now); err != nil {
+		s.peerLog(log.Debug().Err(err).Str("source", "server"), found.auth.Key).Msg("auth session cannot be resumed")
+		s.dropSession(found.auth.Key)
+		return false
+	}
+
+	if err := s.authorize(ctx, peer, found.auth.Key); err != nil {
+		s.dropSession(found.auth.Key)
+		return false
+	}
+
+	s.mx.Lock()
+	found.expiresAt = time.Time{}
+	peer.resumed = true
+	s.mx.Unlock()
+
+	s.bindKey(peer, found.auth.Key)
+	s.peerLog(log.Info(), found.auth.Key).Msg("resumed auth session with peer")
+	s.notifyAuthenticated(found.auth.Key, inbound)
+
+	return true
+}
+
+func (s *Server) verifySession(sess *authSession, adnlID []byte, now time.Time) error {
+	if now.Sub(time.Unix(sess.auth.Timestamp, 0)) > _SessionMaxAge {
+		return fmt.Errorf("session is too old")
+	}
+
+	authData, err := authDigest(adnlID, s.gate.GetID(), sess.auth.Timestamp, sess.auth.Algorithm)
+	if err != nil {
+		return fmt.Errorf("failed to hash their auth data: %w", err)
+	}
+	return verifyAuth(&sess.auth, authData)
+}
+
+// isResumed - checks if peer was authenticated using kept session
+func (s *Server) isResumed(peer *PeerConnection) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return peer.resumed
+}
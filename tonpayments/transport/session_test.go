@@ -0,0 +1,175 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"github.com/xssnick/tonutils-go/adnl"
+	"testing"
+	"time"
+)
+
+func TestServer_SessionResumption(t *testing.T) {
+	svc := &testService{
+		sharedChannels: func(ctx context.Context, key ed25519.PublicKey) ([]SharedChannel, error) {
+			return []SharedChannel{}, nil
+		},
+	}
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, svc)
+	a.SetSessionResumption(time.Minute)
+	b.SetSessionResumption(time.Minute)
+	a.SetQueryRetries(0)
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if err := a.AddStaticPeer(key, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	peer := connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// outbound counters are updated before query returns
+	handshakes := func() uint64 {
+		c := a.QueryStats().Outbound["Authenticate"]
+		return c.Success + c.Failure
+	}
+
+	// connection flap
+	peer.adnl.Close()
+	if _, err := a.GetSharedChannels(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if n := handshakes(); n != 1 {
+		t.Fatal("handshake is not skipped, handshakes:", n)
+	}
+
+	a.mx.RLock()
+	resumed := a.peersByKey[string(key)]
+	a.mx.RUnlock()
+	if resumed == nil || resumed == peer || !a.isResumed(resumed) {
+		t.Fatal("session is not resumed")
+	}
+
+	// both sides lost connection, party resumes our session too
+	aKey := a.channelKey.Public().(ed25519.PublicKey)
+	b.mx.RLock()
+	inbound := b.peersByKey[string(aKey)]
+	b.mx.RUnlock()
+	inbound.adnl.Close()
+	resumed.adnl.Close()
+
+	if _, err := a.GetSharedChannels(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if n := handshakes(); n != 1 {
+		t.Fatal("handshake is not skipped by party, handshakes:", n)
+	}
+
+	b.mx.RLock()
+	inbound = b.peersByKey[string(aKey)]
+	b.mx.RUnlock()
+	if inbound == nil || !b.isResumed(inbound) {
+		t.Fatal("session is not resumed by party")
+	}
+
+	a.mx.RLock()
+	resumed = a.peersByKey[string(key)]
+	a.mx.RUnlock()
+
+	// tampered session must be verified and rejected
+	resumed.adnl.Close()
+	a.mx.Lock()
+	a.sessions[string(key)].auth.Signature[0] ^= 0xFF
+	a.mx.Unlock()
+
+	if _, err := a.GetSharedChannels(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if n := handshakes(); n != 2 {
+		t.Fatal("full handshake is expected, handshakes:", n)
+	}
+
+	// grace period is over
+	a.mx.RLock()
+	last := a.peersByKey[string(key)]
+	a.mx.RUnlock()
+	last.adnl.Close()
+
+	a.mx.Lock()
+	a.sessions[string(key)].expiresAt = time.Now().Add(-time.Second)
+	a.mx.Unlock()
+
+	if _, err := a.GetSharedChannels(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if n := handshakes(); n != 3 {
+		t.Fatal("full handshake is expected after grace, handshakes:", n)
+	}
+}
+
+// idADNL - peer which only has adnl id
+type idADNL struct {
+	adnl.Peer
+	id []byte
+}
+
+func (p *idADNL) GetID() []byte {
+	return p.id
+}
+
+func TestServer_SessionLimit(t *testing.T) {
+	s := newTestServer(t, &testService{})
+	s.SetSessionResumption(time.Minute)
+
+	key := func(i int) []byte {
+		k := make([]byte, 32)
+		binary.BigEndian.PutUint32(k, uint32(i))
+		return k
+	}
+	save := func(i int, adnlID []byte) {
+		s.saveSession(&PeerConnection{adnl: &idADNL{id: adnlID}}, Authenticate{Key: key(i), Timestamp: time.Now().Unix()})
+	}
+
+	for i := 0; i < _MaxSessions; i++ {
+		save(i, key(i))
+	}
+
+	// disconnected session expires first, so it is evicted instead of alive ones
+	s.mx.Lock()
+	s.sessions[string(key(7))].expiresAt = time.Now().Add(time.Second)
+	s.mx.Unlock()
+
+	save(_MaxSessions, key(_MaxSessions))
+
+	s.mx.RLock()
+	total, byADNL := len(s.sessions), len(s.sessionsByADNL)
+	_, evicted := s.sessions[string(key(7))]
+	_, indexed := s.sessionsByADNL[string(key(7))]
+	s.mx.RUnlock()
+
+	if total != _MaxSessions || byADNL != _MaxSessions {
+		t.Fatal("sessions are not capped", total, byADNL)
+	}
+	if evicted || indexed {
+		t.Fatal("session which expires first is not evicted")
+	}
+
+	// adnl id is reused by another party, previous session cannot be found by it anymore
+	save(_MaxSessions+1, key(1))
+
+	s.mx.RLock()
+	_, old := s.sessions[string(key(1))]
+	sess := s.sessionsByADNL[string(key(1))]
+	s.mx.RUnlock()
+
+	if old {
+		t.Fatal("session of previous owner of adnl id is kept")
+	}
+	if sess == nil || string(sess.auth.Key) != string(key(_MaxSessions+1)) {
+		t.Fatal("session is not indexed by adnl id")
+	}
+}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// _RestorePeerTimeout - max time to reconnect to one of persisted peers on startup
+const _RestorePeerTimeout = 30 * time.Second
+
+// PersistedSession - authenticated peer, kept across restarts to reconnect to it eagerly
+type PersistedSession struct {
+	ChannelKey ed25519.PublicKey
+	// ADNLKey - adnl key of the node, nil when connection was initiated by the party
+	ADNLKey ed25519.PublicKey
+	// Addr - last used address of the node, ip:port
+	Addr string
+}
+
+// SessionStore - persistence of authenticated peers, Save is called on server close,
+// Load is called once when store is set
+type SessionStore interface {
+	Save(peers []PersistedSession)
+	Load() []PersistedSession
+}
+
+// SetSessionStore - sets store of authenticated peers. Previously saved peers are loaded and
+// connected in background, their last addresses are used without dht lookup while they work.
+func (s *Server) SetSessionStore(store SessionStore) {
+	s.mx.Lock()
+	s.sessionStore = store
+	s.mx.Unlock()
+
+	if store == nil {
+		return
+	}
+
+	for _, p := range store.Load() {
+		if len(p.ChannelKey) != ed25519.PublicKeySize {
+			continue
+		}
+
+		if len(p.ADNLKey) == ed25519.PublicKeySize && p.Addr != "" {
+			s.dhtCache.put(p.ChannelKey, resolvedNode{
+				key:   append(ed25519.PublicKey{}, p.ADNLKey...),
+				addrs: []string{p.Addr},
+			})
+		}
+		go s.restorePeer(append(ed25519.PublicKey{}, p.ChannelKey...))
+	}
+}
+
+func (s *Server) restorePeer(channelKey ed25519.PublicKey) {
+	ctx, cancel := context.WithTimeout(s.closeCtx, _RestorePeerTimeout)
+	defer cancel()
+
+	if err := s.Warmup(ctx, channelKey); err != nil {
+		s.peerLog(log.Debug().Err(err).Str("source", "server"), channelKey).Msg("failed to restore persisted peer")
+		return
+	}
+	s.peerLog(log.Debug().Str("source", "server"), channelKey).Msg("persisted peer restored")
+}
+
+// persistedSessions - returns authenticated peers, must be called under lock
+func (s *Server) persistedSessions() []PersistedSession {
+	res := make([]PersistedSession, 0, len(s.peersByKey))
+	for _, p := range s.peersByKey {
+		res = append(res, PersistedSession{
+			ChannelKey: append(ed25519.PublicKey{}, p.authKey...),
+			ADNLKey:    p.nodeKey,
+			Addr:       p.adnl.RemoteAddr(),
+		})
+	}
+	return res
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memorySessionStore struct {
+	list []PersistedSession
+	mx   sync.Mutex
+}
+
+func (m *memorySessionStore) Save(peers []PersistedSession) {
+	m.mx.Lock()
+	m.list = peers
+	m.mx.Unlock()
+}
+
+func (m *memorySessionStore) Load() []PersistedSession {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.list
+}
+
+func TestServer_SessionStore(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	store := &memorySessionStore{}
+	a.SetSessionStore(store)
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if err := a.AddStaticPeer(key, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Warmup(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Close()
+
+	list := store.Load()
+	if len(list) != 1 {
+		t.Fatal("incorrect persisted peers", len(list))
+	}
+	if !bytes.Equal(list[0].ChannelKey, key) || !bytes.Equal(list[0].ADNLKey, b.key.Public().(ed25519.PublicKey)) ||
+		list[0].Addr != testAddr(b) {
+		t.Fatal("incorrect persisted peer", list[0])
+	}
+
+	// restarted node, without static peers and dht, reconnects using persisted address
+	restarted := newTestServer(t, &testService{})
+	restarted.SetSessionStore(store)
+
+	for {
+		restarted.mx.RLock()
+		peer := restarted.peersByKey[string(key)]
+		restarted.mx.RUnlock()
+		if peer != nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatal("persisted peer is not restored")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
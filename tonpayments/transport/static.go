@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"net/netip"
+)
+
+// AddStaticPeer - pins node's adnl key and address, connection to this channel key
+// will be established without dht lookup. Repeated call overrides the previous entry.
+func (s *Server) AddStaticPeer(channelKey, adnlKey ed25519.PublicKey, addr string) error {
+	if len(channelKey) != ed25519.PublicKeySize || len(adnlKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("incorrect key size")
+	}
+	if _, err := netip.ParseAddrPort(addr); err != nil {
+		return fmt.Errorf("incorrect address: %w", err)
+	}
+
+	s.mx.Lock()
+	s.staticPeers[string(channelKey)] = resolvedNode{
+		key:   append(ed25519.PublicKey{}, adnlKey...),
+		addrs: []string{addr},
+	}
+	s.mx.Unlock()
+	return nil
+}
+
+// RemoveStaticPeer - removes pinned address, next connections will use dht
+func (s *Server) RemoveStaticPeer(channelKey ed25519.PublicKey) {
+	s.mx.Lock()
+	delete(s.staticPeers, string(channelKey))
+	s.mx.Unlock()
+}
+
+func (s *Server) getStaticPeer(channelKey ed25519.PublicKey) (resolvedNode, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	node, ok := s.staticPeers[string(channelKey)]
+	return node, ok
+}
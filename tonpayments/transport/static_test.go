@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_AddStaticPeer(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{cfg: ChannelConfig{WalletAddr: make([]byte, 32)}})
+
+	channelKey := b.channelKey.Public().(ed25519.PublicKey)
+	if err := a.AddStaticPeer(channelKey, b.key.Public().(ed25519.PublicKey), "bad address"); err == nil {
+		t.Fatal("incorrect address accepted")
+	}
+	if err := a.AddStaticPeer(channelKey, b.key.Public().(ed25519.PublicKey), testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// dht is not configured, so only static entry can be used
+	if _, err := a.GetChannelConfig(ctx, channelKey); err != nil {
+		t.Fatal(err)
+	}
+
+	a.RemoveStaticPeer(channelKey)
+	a.mx.RLock()
+	_, ok := a.staticPeers[string(channelKey)]
+	a.mx.RUnlock()
+	if ok {
+		t.Fatal("static peer is not removed")
+	}
+}
+
+// run with -race, static peers are changed while connections are established
+func TestServer_StaticPeerConcurrentConnect(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	channelKey := b.channelKey.Public().(ed25519.PublicKey)
+	adnlKey := b.key.Public().(ed25519.PublicKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := a.AddStaticPeer(channelKey, adnlKey, testAddr(b)); err != nil {
+					t.Error(err)
+					return
+				}
+				a.RemoveStaticPeer(channelKey)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				// fails when static entry is removed at the moment, dht is not configured
+				if peer, err := a.connect(ctx, channelKey); err == nil {
+					peer.adnl.Close()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := a.AddStaticPeer(channelKey, adnlKey, testAddr(b)); err != nil {
+		t.Fatal(err)
+	}
+	peer, err := a.connect(ctx, channelKey)
+	if err != nil {
+		t.Fatal("static peer is not used after concurrent updates:", err)
+	}
+	peer.adnl.Close()
+}
@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"github.com/rs/zerolog"
+	"sync"
+)
+
+// peerTags - human-readable labels of parties by channel key, for diagnostics.
+// Has its own lock, because logs are written under server lock too.
+type peerTags struct {
+	list map[string]string
+	mx   sync.RWMutex
+}
+
+func (t *peerTags) get(key []byte) string {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	return t.list[string(key)]
+}
+
+// TagPeer - sets label of the party, like "exchange-A", which is added to logs and ListPeers info.
+// Label is kept across reconnects, empty label removes it.
+func (s *Server) TagPeer(channelKey ed25519.PublicKey, label string) {
+	s.tags.mx.Lock()
+	defer s.tags.mx.Unlock()
+
+	if label == "" {
+		delete(s.tags.list, string(channelKey))
+		return
+	}
+
+	if s.tags.list == nil {
+		s.tags.list = map[string]string{}
+	}
+	s.tags.list[string(channelKey)] = label
+}
+
+// peerLog - adds channel key of the party and its label to log event
+func (s *Server) peerLog(ev *zerolog.Event, key []byte) *zerolog.Event {
+	ev = ev.Hex("key", key)
+	if tag := s.tags.get(key); tag != "" {
+		ev = ev.Str("tag", tag)
+	}
+	return ev
+}
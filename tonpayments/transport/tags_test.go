@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"github.com/rs/zerolog"
+	"strings"
+	"testing"
+)
+
+func TestServer_TagPeer(t *testing.T) {
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	a.TagPeer(key, "exchange-A")
+
+	connectTestServers(t, a, b)
+
+	found := false
+	for _, p := range a.ListPeers() {
+		if bytes.Equal(p.AuthKey, key) {
+			found = true
+			if p.Tag != "exchange-A" {
+				t.Fatal("incorrect tag", p.Tag)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("peer is not listed")
+	}
+
+	// peer events are logged with the tag, own logger to not touch the global one
+	logs := &bytes.Buffer{}
+	logger := zerolog.New(logs)
+	a.peerLog(logger.Info(), key).Msg("connected with peer")
+	if !strings.Contains(logs.String(), `"tag":"exchange-A"`) {
+		t.Fatal("tag is not logged:", logs.String())
+	}
+
+	a.TagPeer(key, "")
+	for _, p := range a.ListPeers() {
+		if p.Tag != "" {
+			t.Fatal("tag is not removed")
+		}
+	}
+}
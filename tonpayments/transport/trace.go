@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"github.com/rs/zerolog/log"
+	"github.com/xssnick/tonutils-go/tl"
+	"reflect"
+)
+
+// SetPayloadTrace - logs hex of serialized queries and answers in both directions at trace level,
+// for all peers when all is true, or only for peers with listed channel keys. Payloads contain
+// channel states and signatures, so it is for debugging only, and it is disabled by default.
+// Logger level should allow trace events. Call without keys and with all = false disables it.
+func (s *Server) SetPayloadTrace(all bool, channelKeys ...ed25519.PublicKey) {
+	keys := map[string]bool{}
+	for _, k := range channelKeys {
+		keys[string(k)] = true
+	}
+
+	s.mx.Lock()
+	s.traceAll = all
+	s.traceKeys = keys
+	s.mx.Unlock()
+}
+
+// tracePayload - logs payload when tracing is enabled for the peer, key is taken from peer when it is not known
+func (s *Server) tracePayload(peer *PeerConnection, key []byte, direction string, payload any) {
+	s.mx.RLock()
+	if key == nil && peer != nil {
+		key = peer.authKey
+	}
+	enabled := s.traceAll || (key != nil && s.traceKeys[string(key)])
+	s.mx.RUnlock()
+
+	if !enabled {
+		return
+	}
+
+	ev := log.Trace()
+	if !ev.Enabled() {
+		return
+	}
+
+	if v := reflect.ValueOf(payload); v.Kind() == reflect.Pointer && !v.IsNil() {
+		payload = v.Elem().Interface()
+	}
+
+	data, err := tl.Serialize(wireQuery(payload), true)
+	if err != nil {
+		ev.Discard()
+		log.Debug().Err(err).Str("source", "server").Type("payload", payload).Msg("failed to serialize payload for trace")
+		return
+	}
+	ev.Str("source", "server").Str("direction", direction).Str("type", queryKind(payload)).
+		Hex("key", key).Hex("payload", data).Msg("payload trace")
+}
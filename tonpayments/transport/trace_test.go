@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type syncBuffer struct {
+	buf bytes.Buffer
+	mx  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.buf.String()
+}
+
+func TestServer_PayloadTrace(t *testing.T) {
+	out := &syncBuffer{}
+	prevLogger, prevLevel := log.Logger, zerolog.GlobalLevel()
+	log.Logger = zerolog.New(out).Level(zerolog.TraceLevel)
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	defer func() {
+		log.Logger = prevLogger
+		zerolog.SetGlobalLevel(prevLevel)
+	}()
+
+	a := newTestServer(t, &testService{})
+	b := newTestServer(t, &testService{})
+	connectTestServers(t, a, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := b.channelKey.Public().(ed25519.PublicKey)
+	if _, err := a.GetChannelConfig(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "payload trace") {
+		t.Fatal("payload is logged when trace is disabled")
+	}
+
+	a.SetPayloadTrace(false, key)
+	if _, err := a.GetChannelConfig(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := out.String()
+	if !strings.Contains(logs, `"direction":"outbound query"`) || !strings.Contains(logs, `"direction":"outbound answer"`) {
+		t.Fatal("payloads are not logged")
+	}
+	if strings.Contains(logs, `"direction":"inbound`) {
+		t.Fatal("payloads are logged by party which has no trace enabled")
+	}
+}
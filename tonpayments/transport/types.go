@@ -16,11 +16,23 @@ import (
 	"time"
 )
 
+// Constructor ids are derived from the whole schema line, so any change of fields is incompatible
+// on the wire. Schema below is not compatible with nodes built before authentication algorithm
+// negotiation: authenticate, decision, channel config, request and propose action queries were
+// changed together, and such nodes cannot talk to each other. It is shipped as one coordinated
+// protocol upgrade, without fallback constructors, all nodes of the network should be updated.
 func init() {
-	tl.Register(Decision{}, "payments.decision agreed:Bool reason:string = payments.Decision")
-	tl.Register(ProposalDecision{}, "payments.proposalDecision agreed:Bool reason:string signedState:bytes = payments.ProposalDecision")
-	tl.Register(ChannelConfig{}, "payments.channelConfig excessFee:bytes walletAddr:int256 quarantineDuration:int misbehaviorFine:bytes conditionalCloseDuration:int = payments.ChannelConfig")
-	tl.Register(AuthenticateToSign{}, "payments.authenticateToSign a:int256 b:int256 timestamp:long = payments.AuthenticateToSign")
+	tl.Register(Decision{}, "payments.decision flags:# agreed:Bool reason:string baseStateHash:flags.0?int256 retryAfter:flags.1?long = payments.Decision")
+	tl.Register(ProposalDecision{}, "payments.proposalDecision flags:# agreed:Bool reason:string signedState:bytes retryAfter:flags.0?long = payments.ProposalDecision")
+	tl.Register(ProposalDecisions{}, "payments.proposalDecisions list:(vector payments.proposalDecision) = payments.ProposalDecisions")
+	tl.Register(ChannelConfig{}, "payments.channelConfig excessFee:bytes walletAddr:int256 quarantineDuration:int misbehaviorFine:bytes conditionalCloseDuration:int minCapacity:bytes maxCapacity:bytes = payments.ChannelConfig")
+	tl.Register(AuthenticateToSign{}, "payments.authenticateToSign a:int256 b:int256 timestamp:long algorithm:int = payments.AuthenticateToSign")
+	tl.Register(FeeSchedule{}, "payments.feeSchedule excessFee:bytes virtualChannelFee:bytes validUntil:long = payments.FeeSchedule")
+	tl.Register(Ping{}, "payments.ping timestamp:long = payments.Pong")
+	tl.Register(Pong{}, "payments.pong timestamp:long = payments.Pong")
+	tl.Register(SharedChannel{}, "payments.sharedChannel address:int256 status:int ourSeqno:long theirSeqno:long = payments.SharedChannel")
+	tl.Register(SharedChannels{}, "payments.sharedChannels list:(vector payments.sharedChannel) = payments.SharedChannels")
+	tl.Register(ChannelState{}, "payments.channelState signedState:bytes = payments.ChannelState")
 	tl.Register(NodeAddress{}, "payments.nodeAddress adnl_addr:int256 = payments.NodeAddress")
 
 	tl.Register(ConfirmCloseAction{}, "payments.confirmCloseAction key:int256 state:bytes = payments.Action")
@@ -32,10 +44,21 @@ func init() {
 	tl.Register(IncrementStatesAction{}, "payments.incrementStatesAction wantResponse:Bool = payments.Action")
 
 	tl.Register(GetChannelConfig{}, "payments.getChannelConfig = payments.Request")
-	tl.Register(RequestAction{}, "payments.requestAction channelAddr:int256 action:payments.Action = payments.Request")
-	tl.Register(ProposeAction{}, "payments.proposeAction channelAddr:int256 action:payments.Action state:bytes = payments.Request")
-	tl.Register(RequestInboundChannel{}, "payments.requestInboundChannel key:int256 wallet:int256 capacity:bytes = payments.Request")
-	tl.Register(Authenticate{}, "payments.authenticate key:int256 timestamp:long signature:bytes = payments.Authenticate")
+	tl.Register(GetFeeSchedule{}, "payments.getFeeSchedule = payments.Request")
+	tl.Register(GetSharedChannels{}, "payments.getSharedChannels = payments.Request")
+	tl.Register(GetChannelState{}, "payments.getChannelState channelWorkchain:int channelAddr:int256 = payments.Request")
+	tl.Register(RequestAction{}, "payments.requestAction channelWorkchain:int channelAddr:int256 action:payments.Action = payments.Request")
+	tl.Register(ProposeAction{}, "payments.proposeAction channelWorkchain:int channelAddr:int256 action:payments.Action state:bytes = payments.Request")
+	tl.Register(ProposeActions{}, "payments.proposeActions actions:(vector payments.proposeAction) = payments.Request")
+	tl.Register(NegotiateSettlement{}, "payments.negotiateSettlement interval:long threshold:bytes = payments.Request")
+	tl.Register(ConfirmClose{}, "payments.confirmClose channelWorkchain:int channelAddr:int256 txHash:int256 = payments.Request")
+	tl.Register(GetBalanceAttestation{}, "payments.getBalanceAttestation channelWorkchain:int channelAddr:int256 = payments.Request")
+	tl.Register(BalanceAttestation{}, "payments.balanceAttestation channelWorkchain:int channelAddr:int256 balance:bytes timestamp:long signature:bytes = payments.BalanceAttestation")
+	tl.Register(BalanceAttestationToSign{}, "payments.balanceAttestationToSign channelWorkchain:int channelAddr:int256 balance:bytes timestamp:long = payments.BalanceAttestationToSign")
+	tl.Register(RequestInboundChannel{}, "payments.requestInboundChannel flags:# key:int256 walletWorkchain:int wallet:int256 capacity:bytes jettonMaster:flags.0?int256 = payments.Request")
+	tl.Register(Authenticate{}, "payments.authenticate flags:# key:int256 timestamp:long algorithm:int supported:(vector int) signature:bytes cert:flags.0?payments.ephemeralCert = payments.Authenticate")
+	tl.Register(EphemeralCert{}, "payments.ephemeralCert key:int256 validUntil:long signature:bytes = payments.EphemeralCert")
+	tl.Register(EphemeralCertToSign{}, "payments.ephemeralCertToSign channelKey:int256 key:int256 validUntil:long = payments.EphemeralCertToSign")
 
 	tl.Register(InstructionContainer{}, "payments.instructionContainer hash:int256 data:bytes = payments.InstructionContainer")
 	tl.Register(InstructionsToSign{}, "payments.instructionsToSign list:(vector payments.instructionContainer) = payments.InstructionsToSign")
@@ -44,6 +67,29 @@ func init() {
 
 type Action any
 
+// Ping - keepalive request, peer should answer with Pong containing the same timestamp
+type Ping struct {
+	Timestamp int64 `tl:"long"`
+}
+
+// Pong - answer for Ping
+type Pong struct {
+	Timestamp int64 `tl:"long"`
+}
+
+// SharedChannel - channel between us and peer, seqnos are from the side of the responder
+type SharedChannel struct {
+	Address    []byte `tl:"int256"`
+	Status     int32  `tl:"int"`
+	OurSeqno   int64  `tl:"long"`
+	TheirSeqno int64  `tl:"long"`
+}
+
+// SharedChannels - response for GetSharedChannels
+type SharedChannels struct {
+	List []SharedChannel `tl:"vector struct"`
+}
+
 // NodeAddress - DHT record value which stores adnl addr related to node's public key used for channels
 type NodeAddress struct {
 	ADNLAddr []byte `tl:"int256"`
@@ -51,52 +97,104 @@ type NodeAddress struct {
 
 // Authenticate - auth with both sides adnl ids signature, to establish connection
 type Authenticate struct {
+	Flags     uint32 `tl:"flags"`
 	Key       []byte `tl:"int256"`
 	Timestamp int64  `tl:"long"`
-	// It should be the signature of AuthenticateToSign, signed by node channel key
+	// Algorithm - used to sign this message, in response it is selected from Supported of the initiator
+	Algorithm int32 `tl:"int"`
+	// Supported - algorithms which sender can verify, in order of preference
+	Supported []int32 `tl:"vector int"`
+	// It should be the signature of AuthenticateToSign, signed by node channel key,
+	// or by ephemeral key from Cert, when it is present
+	Signature []byte         `tl:"bytes"`
+	Cert      *EphemeralCert `tl:"?0 struct"`
+}
+
+// EphemeralCert - short-lived key certified by node channel key, can be used to sign auth
+type EphemeralCert struct {
+	Key        []byte `tl:"int256"`
+	ValidUntil int64  `tl:"long"`
+	// Signature of EphemeralCertToSign, signed by node channel key
 	Signature []byte `tl:"bytes"`
 }
 
+type EphemeralCertToSign struct {
+	ChannelKey []byte `tl:"int256"`
+	Key        []byte `tl:"int256"`
+	ValidUntil int64  `tl:"long"`
+}
+
 // AuthenticateToSign - payload to sign for auth, A and B are adnl addresses of parties
 type AuthenticateToSign struct {
 	A         []byte `tl:"int256"`
 	B         []byte `tl:"int256"`
 	Timestamp int64  `tl:"long"`
+	// signed too, to not allow algorithm substitution
+	Algorithm int32 `tl:"int"`
 }
 
 // RequestInboundChannel - request party to deploy channel with us,
 // and initialize it with Capacity amount, to send us coins
 type RequestInboundChannel struct {
-	Key      []byte `tl:"int256"`
-	Wallet   []byte `tl:"int256"`
-	Capacity []byte `tl:"bytes"`
+	Flags           uint32 `tl:"flags"`
+	Key             []byte `tl:"int256"`
+	WalletWorkchain int32  `tl:"int"`
+	Wallet          []byte `tl:"int256"`
+	Capacity        []byte `tl:"bytes"`
+	// JettonMaster - basechain address of jetton which channel should hold,
+	// present when flag 0 is set, otherwise channel is in native TON
+	JettonMaster []byte `tl:"?0 int256"`
 }
 
 // ProposeAction - request party to update state with action,
 // for example open virtual channel and add conditional payment
 type ProposeAction struct {
-	ChannelAddr []byte     `tl:"int256"`
-	Action      any        `tl:"struct boxed [payments.openVirtualAction,payments.closeVirtualAction,payments.confirmCloseAction,payments.removeVirtualAction,payments.syncStateAction,payments.incrementStatesAction]"`
-	SignedState *cell.Cell `tl:"cell"`
+	ChannelWorkchain int32      `tl:"int"`
+	ChannelAddr      []byte     `tl:"int256"`
+	Action           any        `tl:"struct boxed [payments.openVirtualAction,payments.closeVirtualAction,payments.confirmCloseAction,payments.removeVirtualAction,payments.syncStateAction,payments.incrementStatesAction]"`
+	SignedState      *cell.Cell `tl:"cell"`
+}
+
+// ProposeActions - request party to apply all actions atomically, in the same order,
+// if any of them is rejected, none of them are applied
+type ProposeActions struct {
+	Actions []ProposeAction `tl:"vector struct"`
 }
 
 // RequestAction - request party to propose some action
 type RequestAction struct {
-	ChannelAddr []byte `tl:"int256"`
-	Action      any    `tl:"struct boxed [payments.closeVirtualAction,payments.confirmCloseAction,payments.removeVirtualAction,payments.syncStateAction,payments.cooperativeCloseAction,payments.requestRemoveVirtualAction]"`
+	ChannelWorkchain int32  `tl:"int"`
+	ChannelAddr      []byte `tl:"int256"`
+	Action           any    `tl:"struct boxed [payments.closeVirtualAction,payments.confirmCloseAction,payments.removeVirtualAction,payments.syncStateAction,payments.cooperativeCloseAction,payments.requestRemoveVirtualAction]"`
 }
 
 // Decision - response for actions request, Reason is filled when not agreed
 type Decision struct {
+	Flags  uint32 `tl:"flags"`
 	Agreed bool   `tl:"bool"`
 	Reason string `tl:"string"`
+	// BaseStateHash - hash of party's channel state at the moment RequestAction was accepted, present when flag 0 is set.
+	// Requested action is applied later, so it is the state before the action, not the resulting one.
+	BaseStateHash []byte `tl:"?0 int256"`
+	// RetryAfter - seconds, present when flag 1 is set, in rejection of RequestInboundChannel
+	// because of insufficient liquidity, or in rejection of any query because of party's load,
+	// request can be repeated after it
+	RetryAfter int64 `tl:"?1 long"`
 }
 
 // ProposalDecision - response for actions proposals, Reason is filled when not agreed
 type ProposalDecision struct {
+	Flags       uint32     `tl:"flags"`
 	Agreed      bool       `tl:"bool"`
 	Reason      string     `tl:"string"`
 	SignedState *cell.Cell `tl:"cell optional"`
+	// RetryAfter - seconds, present when flag 0 is set, in rejection because of party's load
+	RetryAfter int64 `tl:"?0 long"`
+}
+
+// ProposalDecisions - response for batch actions proposal, decision per action in the same order
+type ProposalDecisions struct {
+	List []ProposalDecision `tl:"vector struct"`
 }
 
 // OpenVirtualAction - request party to open virtual channel (tunnel) with specified target
@@ -186,6 +284,77 @@ type ChannelConfig struct {
 	QuarantineDuration       uint32 `tl:"int"`
 	MisbehaviorFine          []byte `tl:"bytes"`
 	ConditionalCloseDuration uint32 `tl:"int"`
+	// MinCapacity - min capacity that party accepts in one RequestInboundChannel, zero means no minimum.
+	// MaxCapacity - aggregate limit, not for one request: capacity of the request plus capacity of all
+	// channels already deployed with requester must not exceed it, so available amount can be less.
+	MinCapacity []byte `tl:"bytes"`
+	MaxCapacity []byte `tl:"bytes"`
+}
+
+// GetFeeSchedule - request fees which party takes for its services
+type GetFeeSchedule struct{}
+
+// GetSharedChannels - request channels between party and us, requires authentication
+type GetSharedChannels struct{}
+
+// GetChannelState - request party's latest signed state of the channel, requires authentication
+type GetChannelState struct {
+	ChannelWorkchain int32  `tl:"int"`
+	ChannelAddr      []byte `tl:"int256"`
+}
+
+// ChannelState - response for GetChannelState
+type ChannelState struct {
+	SignedState *cell.Cell `tl:"cell"`
+}
+
+// NegotiateSettlement - propose party how often channel should be settled onchain,
+// Interval is in seconds, Threshold is the balance in nanoTON which triggers settlement, zero means not used.
+// Party answers with Decision, and records the policy when agreed. Requires authentication.
+type NegotiateSettlement struct {
+	Interval  int64  `tl:"long"`
+	Threshold []byte `tl:"bytes"`
+}
+
+// ConfirmClose - notify party that settlement transaction of agreed cooperative close is submitted,
+// so it can track it onchain. Party answers with Decision. Requires authentication.
+type ConfirmClose struct {
+	ChannelWorkchain int32  `tl:"int"`
+	ChannelAddr      []byte `tl:"int256"`
+	TxHash           []byte `tl:"int256"`
+}
+
+// GetBalanceAttestation - request party's signed statement of its current balance in the channel,
+// party answers with BalanceAttestation. Requires authentication.
+type GetBalanceAttestation struct {
+	ChannelWorkchain int32  `tl:"int"`
+	ChannelAddr      []byte `tl:"int256"`
+}
+
+// BalanceAttestation - response for GetBalanceAttestation, Balance is in nanoTON (or jetton units),
+// Timestamp is unix time when it was made
+type BalanceAttestation struct {
+	ChannelWorkchain int32  `tl:"int"`
+	ChannelAddr      []byte `tl:"int256"`
+	Balance          []byte `tl:"bytes"`
+	Timestamp        int64  `tl:"long"`
+	// Signature of BalanceAttestationToSign, signed by node channel key
+	Signature []byte `tl:"bytes"`
+}
+
+type BalanceAttestationToSign struct {
+	ChannelWorkchain int32  `tl:"int"`
+	ChannelAddr      []byte `tl:"int256"`
+	Balance          []byte `tl:"bytes"`
+	Timestamp        int64  `tl:"long"`
+}
+
+// FeeSchedule - response of GetFeeSchedule, fees are guaranteed till ValidUntil (unix time)
+type FeeSchedule struct {
+	ExcessFee []byte `tl:"bytes"`
+	// VirtualChannelFee - min fee for tunnelling virtual channel through party
+	VirtualChannelFee []byte `tl:"bytes"`
+	ValidUntil        int64  `tl:"long"`
 }
 
 func (a *OpenVirtualAction) SetInstructions(actions []OpenVirtualInstruction, key ed25519.PrivateKey) error {
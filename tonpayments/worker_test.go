@@ -0,0 +1,119 @@
+package tonpayments
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"github.com/xssnick/ton-payment-network/tonpayments/db"
+	"github.com/xssnick/ton-payment-network/tonpayments/transport"
+	"github.com/xssnick/tonutils-go/address"
+	"testing"
+	"time"
+)
+
+func TestService_ExecuteTask_Rejection(t *testing.T) {
+	ourPub, ourKey, _ := ed25519.GenerateKey(nil)
+	theirPub, _, _ := ed25519.GenerateKey(nil)
+	addr := address.MustParseAddr("EQAYqo4u7VF0fa4DPAebk4g9lBytj2VFny7pzXR0trjtXQaO")
+
+	data, err := json.Marshal(db.AskRemoveVirtualTask{
+		Key:            ourPub,
+		ChannelAddress: addr.String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		reject     error
+		retry      bool
+		retryAfter time.Duration
+	}{
+		{name: "denied", reject: &transport.DecisionError{Reason: "not allowed"}},
+		{name: "busy", reject: &transport.RetryableError{Err: transport.ErrBusy, RetryAfter: 3 * time.Second}, retry: true, retryAfter: 3 * time.Second},
+		{name: "rate limited", reject: &transport.RetryableError{Err: transport.ErrRateLimited}, retry: true, retryAfter: 10 * time.Second},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var retryAt time.Time
+			var retried, completed bool
+
+			svc := &Service{
+				key: ourKey,
+				transport: &testTransport{
+					requestAction: func(ctx context.Context, channelAddr *address.Address, theirChannelKey []byte, action transport.Action) (*transport.Decision, error) {
+						return nil, tt.reject
+					},
+				},
+				db: &testDB{
+					getChannel: func(ctx context.Context, a string) (*db.Channel, error) {
+						return &db.Channel{
+							Address:      addr.String(),
+							Status:       db.ChannelStateActive,
+							TheirOnchain: db.OnchainState{Key: theirPub},
+							Our:          db.NewSide(make([]byte, 16), 0, 0),
+							Their:        db.NewSide(make([]byte, 16), 0, 0),
+						}, nil
+					},
+					retryTask: func(ctx context.Context, task *db.Task, reason string, at time.Time) error {
+						retried, retryAt = true, at
+						return nil
+					},
+					completeTask: func(ctx context.Context, task *db.Task) error {
+						completed = true
+						return nil
+					},
+				},
+			}
+
+			start := time.Now()
+			svc.executeTask(&db.Task{ID: "1", Type: "ask-remove-virtual", Data: data})
+
+			if !tt.retry {
+				if retried || !completed {
+					t.Fatal("denied request should complete the task")
+				}
+				return
+			}
+
+			if !retried || completed {
+				t.Fatal("task should be retried after transient rejection")
+			}
+			if d := retryAt.Sub(start); d < tt.retryAfter || d > tt.retryAfter+time.Second {
+				t.Fatalf("unexpected retry time: %s", d)
+			}
+		})
+	}
+}
+
+func TestService_RequestAction_Retryable(t *testing.T) {
+	_, ourKey, _ := ed25519.GenerateKey(nil)
+	addr := address.MustParseAddr("EQAYqo4u7VF0fa4DPAebk4g9lBytj2VFny7pzXR0trjtXQaO")
+
+	svc := &Service{
+		key: ourKey,
+		transport: &testTransport{
+			requestAction: func(ctx context.Context, channelAddr *address.Address, theirChannelKey []byte, action transport.Action) (*transport.Decision, error) {
+				return nil, &transport.RetryableError{Err: transport.ErrBusy, RetryAfter: time.Second}
+			},
+		},
+		db: &testDB{
+			getChannel: func(ctx context.Context, a string) (*db.Channel, error) {
+				return &db.Channel{
+					Address: addr.String(),
+					Our:     db.NewSide(make([]byte, 16), 0, 0),
+					Their:   db.NewSide(make([]byte, 16), 0, 0),
+				}, nil
+			},
+		},
+	}
+
+	err := svc.requestAction(context.Background(), addr.String(), transport.RequestRemoveVirtualAction{})
+	if err == nil || errors.Is(err, ErrDenied) {
+		t.Fatalf("busy party should not deny request, got: %v", err)
+	}
+	if !errors.Is(err, transport.ErrBusy) {
+		t.Fatalf("busy error should be kept, got: %v", err)
+	}
+}